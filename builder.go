@@ -30,6 +30,7 @@ func (b *ProxyRouteBuilder) AddRoute(path, url string) *ProxyRouteBuilder {
 		Methods:          []string{},
 		MiddlewareParams: map[string]interface{}{},
 		HostsPassthrough: []*HostMatcher{},
+		NoProxyHosts:     []*HostMatcher{},
 	})
 	b.index++
 	return b
@@ -44,6 +45,7 @@ func (b *ProxyRouteBuilder) AddRouteHandler(path string, forwardHandler http.Han
 		Methods:          []string{},
 		MiddlewareParams: map[string]interface{}{},
 		HostsPassthrough: []*HostMatcher{},
+		NoProxyHosts:     []*HostMatcher{},
 	})
 	b.index++
 	return b
@@ -89,6 +91,77 @@ func (b *ProxyRouteBuilder) WithSensitiveHeaders(headers ...string) *ProxyRouteB
 	return b
 }
 
+// WithPolicy Set a policy evaluated after allowed and denied groups
+func (b *ProxyRouteBuilder) WithPolicy(policy Policy) *ProxyRouteBuilder {
+	b.currentRoute().Policy = &policy
+	return b
+}
+
+// WithPolicyFile Set path to a yaml or json file containing a policy
+func (b *ProxyRouteBuilder) WithPolicyFile(path string) *ProxyRouteBuilder {
+	b.currentRoute().PolicyFile = path
+	return b
+}
+
+// WithErrorHandler Set handler rendering errors generated by gobis on this route
+func (b *ProxyRouteBuilder) WithErrorHandler(errorHandler ErrorHandler) *ProxyRouteBuilder {
+	b.currentRoute().ErrorHandler = errorHandler
+	return b
+}
+
+// WithErrorFormat Set format of errors generated by gobis on this route: json, problem or html
+// An html template file can be given when format is html
+func (b *ProxyRouteBuilder) WithErrorFormat(format string, templateFile ...string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.ErrorFormat = format
+	if len(templateFile) > 0 {
+		rte.ErrorTemplateFile = templateFile[0]
+	}
+	return b
+}
+
+// AddAllowedGroups Only users having one of these groups can use this route, glob patterns are allowed
+func (b *ProxyRouteBuilder) AddAllowedGroups(groups ...string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.AllowedGroups = append(rte.AllowedGroups, groups...)
+	return b
+}
+
+// AddDeniedGroups Users having one of these groups can't use this route, glob patterns are allowed
+func (b *ProxyRouteBuilder) AddDeniedGroups(groups ...string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.DeniedGroups = append(rte.DeniedGroups, groups...)
+	return b
+}
+
+// WithClientCert Set usage of client certificate for this route: required, optional or ignored
+func (b *ProxyRouteBuilder) WithClientCert(mode ClientCertMode) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.ClientCert = mode
+	return b
+}
+
+// AddProtectedHeaders Add headers which cannot be removed by sensitive headers
+func (b *ProxyRouteBuilder) AddProtectedHeaders(headers ...string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.ProtectedHeaders = append(rte.ProtectedHeaders, headers...)
+	return b
+}
+
+// AddRequestHeaders Add rules to rewrite headers sent to upstream
+func (b *ProxyRouteBuilder) AddRequestHeaders(rules ...HeaderRule) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.RequestHeaders = append(rte.RequestHeaders, rules...)
+	return b
+}
+
+// AddResponseHeaders Add rules to rewrite headers received from upstream
+func (b *ProxyRouteBuilder) AddResponseHeaders(rules ...HeaderRule) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.ResponseHeaders = append(rte.ResponseHeaders, rules...)
+	return b
+}
+
 func (b *ProxyRouteBuilder) AddHostPassthrough(hostsOrWildcards ...string) *ProxyRouteBuilder {
 	rte := b.currentRoute()
 	hostMatchers := make([]*HostMatcher, len(hostsOrWildcards))
@@ -112,6 +185,42 @@ func (b *ProxyRouteBuilder) WithHttpProxy(httpProxy, httpsProxy string) *ProxyRo
 	return b
 }
 
+func (b *ProxyRouteBuilder) WithPACFile(pacFile string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.PACFile = pacFile
+	return b
+}
+
+func (b *ProxyRouteBuilder) WithPACScript(pacScript string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.PACScript = pacScript
+	return b
+}
+
+func (b *ProxyRouteBuilder) WithProxyAuth(proxyAuth ProxyAuth) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	rte.ProxyAuth = &proxyAuth
+	return b
+}
+
+func (b *ProxyRouteBuilder) AddNoProxyHosts(hostsOrWildcards ...string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	for _, hostOrWildcard := range hostsOrWildcards {
+		rte.NoProxyHosts = append(rte.NoProxyHosts, NewHostMatcher(hostOrWildcard))
+	}
+	return b
+}
+
+// AddResolve Force addresses to use when connecting to hostPort (host:port or only a host)
+func (b *ProxyRouteBuilder) AddResolve(hostPort string, addrs ...string) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	if rte.Resolve == nil {
+		rte.Resolve = make(map[string][]string)
+	}
+	rte.Resolve[hostPort] = append(rte.Resolve[hostPort], addrs...)
+	return b
+}
+
 func (b *ProxyRouteBuilder) WithoutProxy() *ProxyRouteBuilder {
 	rte := b.currentRoute()
 	rte.NoProxy = true
@@ -160,6 +269,22 @@ func (b *ProxyRouteBuilder) WithForwardedHeader(header string) *ProxyRouteBuilde
 	return b
 }
 
+// WithParamsForMiddleware Set params given only to middleware with this name
+func (b *ProxyRouteBuilder) WithParamsForMiddleware(name string, params interface{}) *ProxyRouteBuilder {
+	rte := b.currentRoute()
+	if rte.ParamsByMiddleware == nil {
+		rte.ParamsByMiddleware = make(map[string]interface{})
+	}
+	rte.ParamsByMiddleware[name] = params
+	return b
+}
+
+// WithMiddlewares Set names of middlewares to use on this route in the order they are called
+func (b *ProxyRouteBuilder) WithMiddlewares(names ...string) *ProxyRouteBuilder {
+	b.currentRoute().Middlewares = append(make([]string, 0, len(names)), names...)
+	return b
+}
+
 func (b *ProxyRouteBuilder) WithMiddlewareParams(params ...interface{}) *ProxyRouteBuilder {
 	rte := b.currentRoute()
 
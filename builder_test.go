@@ -27,6 +27,12 @@ var _ = Describe("ProxyRouteBuilder", func() {
 				WithShowError().
 				WithOptionsPassthrough().
 				AddHostPassthrough("myhost.com", "*.passthrough.com").
+				WithProxyAuth(ProxyAuth{Username: "user", PasswordEnv: "PROXY_PASSWORD"}).
+				AddNoProxyHosts("*.internal.com").
+				AddResolve("url.com:443", "10.0.0.1").
+				AddProtectedHeaders("X-Protected").
+				AddRequestHeaders(HeaderRule{Action: HeaderActionSet, Name: "X-User", Value: "{{ .Username }}"}).
+				AddResponseHeaders(HeaderRule{Action: HeaderActionRemove, Name: "Server"}).
 				Build()
 
 			finalRte := routes[0]
@@ -46,6 +52,14 @@ var _ = Describe("ProxyRouteBuilder", func() {
 			Expect(finalRte.HostsPassthrough).Should(HaveLen(2))
 			Expect(finalRte.HostsPassthrough[0].String()).Should(Equal("myhost.com"))
 			Expect(finalRte.HostsPassthrough[1].String()).Should(Equal("*.passthrough.com"))
+			Expect(finalRte.ProxyAuth.Username).Should(Equal("user"))
+			Expect(finalRte.ProxyAuth.PasswordEnv).Should(Equal("PROXY_PASSWORD"))
+			Expect(finalRte.NoProxyHosts).Should(HaveLen(1))
+			Expect(finalRte.NoProxyHosts[0].String()).Should(Equal("*.internal.com"))
+			Expect(finalRte.Resolve).Should(HaveKeyWithValue("url.com:443", []string{"10.0.0.1"}))
+			Expect(finalRte.RequestHeaders).Should(HaveLen(1))
+			Expect(finalRte.ProtectedHeaders).Should(Equal([]string{"X-Protected"}))
+			Expect(finalRte.ResponseHeaders[0].Name).Should(Equal("Server"))
 		})
 		It("should create with forward handler when given", func() {
 			routes := builder.AddRouteHandler("/aroute", http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
@@ -0,0 +1,181 @@
+package gobis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type ClientCertMode string
+
+const (
+	// ClientCertOptional Identity is taken from client certificate when one is given (default)
+	ClientCertOptional ClientCertMode = "optional"
+	// ClientCertRequired Requests without a verified client certificate are refused
+	ClientCertRequired ClientCertMode = "required"
+	// ClientCertIgnored Client certificate is not used for this route
+	ClientCertIgnored ClientCertMode = "ignored"
+)
+
+const (
+	// ClientCertUsernameFromCN Username is common name of certificate subject (default)
+	ClientCertUsernameFromCN = "subject_cn"
+	// ClientCertUsernameFromSubject Username is full certificate subject, e.g.: CN=user,OU=dev,O=org
+	ClientCertUsernameFromSubject = "subject"
+	// ClientCertUsernameFromSanDNS Username is first dns name in certificate SAN
+	ClientCertUsernameFromSanDNS = "san_dns"
+	// ClientCertUsernameFromSanEmail Username is first email address in certificate SAN
+	ClientCertUsernameFromSanEmail = "san_email"
+	// ClientCertUsernameFromSanURI Username is first uri in certificate SAN
+	ClientCertUsernameFromSanURI = "san_uri"
+)
+
+// ClientCertConfig Configuration to authenticate clients with certificates on tls listeners served by gobis
+// When username has already been set by an identity source, groups from certificate are only added if it is the same user
+type ClientCertConfig struct {
+	// CAFile Path to a PEM bundle of certificate authorities used to verify client certificates
+	CAFile string `json:"ca_file" yaml:"ca_file"`
+	// UsernameFrom Where username is taken from in certificate: subject_cn, subject, san_dns, san_email or san_uri (Default: subject_cn)
+	UsernameFrom string `json:"username_from" yaml:"username_from"`
+	// GroupsFromOU Set to true to add organizational units of certificate subject as groups
+	GroupsFromOU bool `json:"groups_from_ou" yaml:"groups_from_ou"`
+	// GroupsSanURIPrefix Uris in certificate SAN starting with this prefix are added as groups (prefix is removed)
+	// e.g.: with prefix `urn:group:` uri `urn:group:admin` gives group admin
+	GroupsSanURIPrefix string `json:"groups_san_uri_prefix" yaml:"groups_san_uri_prefix"`
+	// MappingFile Path to a yaml or json file mapping username (or full subject) to a list of groups
+	// e.g.: {"alice": ["admin"], "CN=bob,O=org": ["dev"]}
+	MappingFile string `json:"mapping_file" yaml:"mapping_file"`
+}
+
+// ClientCertMapper Verify client certificates and map them to username and groups
+type ClientCertMapper struct {
+	config  ClientCertConfig
+	pool    *x509.CertPool
+	mapping map[string][]string
+}
+
+func NewClientCertMapper(config ClientCertConfig) (*ClientCertMapper, error) {
+	if config.CAFile == "" {
+		return nil, fmt.Errorf("client cert: ca_file must be set")
+	}
+	b, err := os.ReadFile(config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("client cert: cannot read ca file: %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("client cert: no certificate found in %s", config.CAFile)
+	}
+	if config.UsernameFrom == "" {
+		config.UsernameFrom = ClientCertUsernameFromCN
+	}
+	switch config.UsernameFrom {
+	case ClientCertUsernameFromCN, ClientCertUsernameFromSubject, ClientCertUsernameFromSanDNS,
+		ClientCertUsernameFromSanEmail, ClientCertUsernameFromSanURI:
+	default:
+		return nil, fmt.Errorf("client cert: invalid username_from '%s'", config.UsernameFrom)
+	}
+	mapping := make(map[string][]string)
+	if config.MappingFile != "" {
+		b, err := os.ReadFile(config.MappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("client cert: cannot read mapping file: %s", err.Error())
+		}
+		// yaml is a superset of json
+		err = yaml.Unmarshal(b, &mapping)
+		if err != nil {
+			return nil, fmt.Errorf("client cert: invalid mapping file: %s", err.Error())
+		}
+	}
+	return &ClientCertMapper{
+		config:  config,
+		pool:    pool,
+		mapping: mapping,
+	}, nil
+}
+
+// TLSConfig Create a tls config which request and verify client certificates
+// Certificates are not required at tls level to let routes decide if they are required
+// Server certificates must be added to returned config
+func (m *ClientCertMapper) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  m.pool,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// ClientCertificate Retrieve verified client certificate from a request, nil is returned if there is none
+func ClientCertificate(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// handle Set identity from client certificate according to route mode, false is returned when request must be refused
+func (m *ClientCertMapper) handle(mode ClientCertMode, req *http.Request) bool {
+	if mode == ClientCertIgnored {
+		return true
+	}
+	cert := ClientCertificate(req)
+	if cert == nil {
+		return mode != ClientCertRequired
+	}
+	username := m.username(cert)
+	currentUsername := Username(req)
+	if currentUsername != "" && currentUsername != username {
+		// user has been identified by another source, certificate must not give it groups
+		return true
+	}
+	if username != "" && currentUsername == "" {
+		SetUsername(req, username)
+	}
+	AddGroups(req, m.groups(cert, username)...)
+	return true
+}
+
+func (m *ClientCertMapper) username(cert *x509.Certificate) string {
+	switch m.config.UsernameFrom {
+	case ClientCertUsernameFromSubject:
+		return cert.Subject.String()
+	case ClientCertUsernameFromSanDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case ClientCertUsernameFromSanEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case ClientCertUsernameFromSanURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+func (m *ClientCertMapper) groups(cert *x509.Certificate, username string) []string {
+	groups := make([]string, 0)
+	if m.config.GroupsFromOU {
+		groups = append(groups, cert.Subject.OrganizationalUnit...)
+	}
+	if m.config.GroupsSanURIPrefix != "" {
+		for _, uri := range cert.URIs {
+			if group, ok := strings.CutPrefix(uri.String(), m.config.GroupsSanURIPrefix); ok && group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	groups = append(groups, m.mapping[username]...)
+	if subject := cert.Subject.String(); subject != username {
+		groups = append(groups, m.mapping[subject]...)
+	}
+	return groups
+}
@@ -0,0 +1,198 @@
+package gobis_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+func createCert(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return cert, key
+}
+
+var _ = Describe("ClientCert", func() {
+	var caCert *x509.Certificate
+	var clientCert *x509.Certificate
+	var clientKey *ecdsa.PrivateKey
+	var config ClientCertConfig
+	var username string
+	var groups []string
+	var identitySources []IdentitySource
+	var dir string
+	BeforeEach(func() {
+		var caKey *ecdsa.PrivateKey
+		caCert, caKey = createCert(&x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "test ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}, nil, nil)
+		groupUri, _ := url.Parse("urn:group:ops")
+		clientCert, clientKey = createCert(&x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"dev"}},
+			URIs:         []*url.URL{groupUri},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caCert, caKey)
+
+		var err error
+		dir, err = os.MkdirTemp("", "gobis-client-cert")
+		Expect(err).NotTo(HaveOccurred())
+		caFile := filepath.Join(dir, "ca.pem")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0600)).To(Succeed())
+		mappingFile := filepath.Join(dir, "mapping.yml")
+		Expect(os.WriteFile(mappingFile, []byte("alice: [admin]\n"), 0600)).To(Succeed())
+		config = ClientCertConfig{
+			CAFile:             caFile,
+			GroupsFromOU:       true,
+			GroupsSanURIPrefix: "urn:group:",
+			MappingFile:        mappingFile,
+		}
+		username = ""
+		groups = nil
+		identitySources = nil
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+	newServer := func(routes ...ProxyRoute) *httptest.Server {
+		for i := range routes {
+			routes[i].ForwardHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				username = Username(r)
+				groups = Groups(r)
+				sort.Strings(groups)
+			})
+		}
+		handler, err := NewDefaultHandler(DefaultHandlerConfig{
+			ClientCert:      &config,
+			IdentitySources: identitySources,
+			Routes:          routes,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		server := httptest.NewUnstartedServer(handler)
+		server.TLS = handler.(*DefaultHandler).TLSConfig()
+		Expect(server.TLS).ShouldNot(BeNil())
+		server.StartTLS()
+		return server
+	}
+	newClient := func(server *httptest.Server, withCert bool) *http.Client {
+		client := server.Client()
+		if withCert {
+			client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{
+				{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey},
+			}
+		}
+		return client
+	}
+	It("should map verified client certificate to username and groups", func() {
+		server := newServer(ProxyRoute{Name: "app", Path: NewPathMatcher("/app/**")})
+		defer server.Close()
+		resp, err := newClient(server, true).Get(server.URL + "/app/path")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+		Expect(username).Should(Equal("alice"))
+		Expect(groups).Should(Equal([]string{"admin", "dev", "ops"}))
+	})
+	It("should not give groups of certificate to user identified by another source", func() {
+		identitySources = []IdentitySource{
+			{TrustedCIDRs: []string{"127.0.0.1"}, UsernameHeader: "X-Remote-User"},
+		}
+		server := newServer(ProxyRoute{Name: "app", Path: NewPathMatcher("/app/**")})
+		defer server.Close()
+		client := newClient(server, true)
+
+		req, _ := http.NewRequest("GET", server.URL+"/app/path", nil)
+		req.Header.Set("X-Remote-User", "bob")
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+		Expect(username).Should(Equal("bob"))
+		Expect(groups).Should(BeEmpty())
+
+		req, _ = http.NewRequest("GET", server.URL+"/app/path", nil)
+		req.Header.Set("X-Remote-User", "alice")
+		resp, err = client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+		Expect(username).Should(Equal("alice"))
+		Expect(groups).Should(Equal([]string{"admin", "dev", "ops"}))
+	})
+	It("should refuse requests without certificate on route requiring one", func() {
+		server := newServer(
+			ProxyRoute{Name: "required", Path: NewPathMatcher("/required/**"), ClientCert: ClientCertRequired},
+			ProxyRoute{Name: "optional", Path: NewPathMatcher("/optional/**")},
+		)
+		defer server.Close()
+		client := newClient(server, false)
+		resp, err := client.Get(server.URL + "/required/path")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusUnauthorized))
+
+		resp, err = client.Get(server.URL + "/optional/path")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+		Expect(username).Should(BeEmpty())
+	})
+	It("should refuse requests without certificate on passthrough route requiring one", func() {
+		server := newServer(ProxyRoute{
+			Name:               "app",
+			Path:               NewPathMatcher("/app/**"),
+			ClientCert:         ClientCertRequired,
+			OptionsPassthrough: true,
+		})
+		defer server.Close()
+		req, err := http.NewRequest(http.MethodOptions, server.URL+"/app/path", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Access-Control-Request-Method", "GET")
+
+		resp, err := newClient(server, false).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusUnauthorized))
+
+		resp, err = newClient(server, true).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+	})
+	It("should not use certificate on route ignoring it", func() {
+		server := newServer(ProxyRoute{Name: "app", Path: NewPathMatcher("/app/**"), ClientCert: ClientCertIgnored})
+		defer server.Close()
+		resp, err := newClient(server, true).Get(server.URL + "/app/path")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+		Expect(username).Should(BeEmpty())
+	})
+	It("should refuse invalid configuration", func() {
+		_, err := NewClientCertMapper(ClientCertConfig{CAFile: config.CAFile, UsernameFrom: "unknown"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,29 @@
+// Command gobis-schema Write JSON Schema of gobis configuration, without middleware params, to a file or stdout
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/orange-cloudfoundry/gobis"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+func main() {
+	output := flag.String("o", "", "file where schema is written (default: stdout)")
+	flag.Parse()
+	b, err := json.MarshalIndent(gobis.GenerateJSONSchema(), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b = append(b, '\n')
+	if *output == "" {
+		if _, err := os.Stdout.Write(b); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := os.WriteFile(*output, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -10,62 +10,150 @@ import (
 
 const (
 	dirtyHeadersKey GobisContextKey = iota
+	dirtyResponseHeadersKey
 )
 
 type GobisContextKey int
 
 // DirtHeader Mark a http header as dirty
 // Useful to prevent some headers added and used by middleware to not be sent to upstream
-// if oldValue is not empty it will make proxy rewrite header with this value
-func DirtHeader(req *http.Request, header string, _ ...string) {
-	var dirtyHeaders = make(map[string]string)
+// if oldValues are given proxy will rewrite header with these values, otherwise header is dropped
+// A single empty old value drops header too, e.g.: DirtHeader(req, h, req.Header.Get(h)) when header is not set
+func DirtHeader(req *http.Request, header string, oldValues ...string) {
+	markDirty(req, dirtyHeadersKey, header, givenValues(oldValues))
+}
+
+// DirtHeaderRestore Mark a http header as dirty and make proxy restore its current values before sending to upstream
+// If header is not set in request it will be dropped
+func DirtHeaderRestore(req *http.Request, header string) {
+	markDirty(req, dirtyHeadersKey, header, cloneValues(req.Header.Values(header)))
+}
+
+// DirtHeaderDrop Mark a http header as dirty and make proxy remove it before sending to upstream
+func DirtHeaderDrop(req *http.Request, header string) {
+	markDirty(req, dirtyHeadersKey, header, nil)
+}
+
+// IsDirtyHeader Return true if a http header is marked as dirty
+func IsDirtyHeader(req *http.Request, header string) bool {
+	return isDirty(DirtyHeadersValues(req), header)
+}
+
+// UndirtHeader Remove a http header from the list of dirty header
+func UndirtHeader(req *http.Request, header string) {
+	undirty(DirtyHeadersValues(req), header)
+}
+
+// DirtyHeaders Retrieve all http headers marked as dirty with the first value they will be rewritten with
+// An empty value means header will be dropped, use DirtyHeadersValues to get all values
+// Returned map is a copy, use DirtHeader or UndirtHeader to change dirty headers
+func DirtyHeaders(req *http.Request) *map[string]string {
+	dirtyHeadersPtr := DirtyHeadersValues(req)
+	if dirtyHeadersPtr == nil {
+		return nil
+	}
+	dirtyHeaders := make(map[string]string, len(*dirtyHeadersPtr))
+	for header, values := range *dirtyHeadersPtr {
+		dirtyHeaders[header] = ""
+		if len(values) > 0 {
+			dirtyHeaders[header] = values[0]
+		}
+	}
+	return &dirtyHeaders
+}
+
+// DirtyHeadersValues Retrieve all http headers marked as dirty
+// A nil value means header will be dropped, otherwise header will be set with these values
+func DirtyHeadersValues(req *http.Request) *map[string][]string {
+	return dirtyHeadersFromContext(req, dirtyHeadersKey)
+}
+
+// DirtResponseHeader Mark a http response header as dirty
+// Useful to prevent headers added to the response by middlewares to be sent back to client
+// if values are given header will be rewritten with these values, otherwise header is dropped
+func DirtResponseHeader(req *http.Request, header string, values ...string) {
+	markDirty(req, dirtyResponseHeadersKey, header, givenValues(values))
+}
+
+// IsDirtyResponseHeader Return true if a http response header is marked as dirty
+func IsDirtyResponseHeader(req *http.Request, header string) bool {
+	return isDirty(DirtyResponseHeaders(req), header)
+}
+
+// UndirtResponseHeader Remove a http response header from the list of dirty response header
+func UndirtResponseHeader(req *http.Request, header string) {
+	undirty(DirtyResponseHeaders(req), header)
+}
+
+// DirtyResponseHeaders Retrieve all http response headers marked as dirty
+// A nil value means header will be dropped, otherwise header will be set with these values
+func DirtyResponseHeaders(req *http.Request) *map[string][]string {
+	return dirtyHeadersFromContext(req, dirtyResponseHeadersKey)
+}
+
+func markDirty(req *http.Request, key GobisContextKey, header string, values []string) {
 	header = sanitizeHeaderName(header)
-	oldVal := ""
-	dirtyHeadersPtr := DirtyHeaders(req)
+	dirtyHeadersPtr := dirtyHeadersFromContext(req, key)
 	if dirtyHeadersPtr == nil {
-		dirtyHeaders[header] = oldVal
-		AddContextValue(req, dirtyHeadersKey, &dirtyHeaders)
+		dirtyHeaders := map[string][]string{header: values}
+		AddContextValue(req, key, &dirtyHeaders)
 		return
 	}
-	dirtyHeaders = *dirtyHeadersPtr
-	dirtyHeaders[header] = oldVal
-	*dirtyHeadersPtr = dirtyHeaders
+	(*dirtyHeadersPtr)[header] = values
 }
 
-// IsDirtyHeader Return true if a http header is marked as dirty
-func IsDirtyHeader(req *http.Request, header string) bool {
-	header = sanitizeHeaderName(header)
-	dirtyHeadersPtr := DirtyHeaders(req)
+func isDirty(dirtyHeadersPtr *map[string][]string, header string) bool {
 	if dirtyHeadersPtr == nil {
 		return false
 	}
-	dirtyHeaders := *dirtyHeadersPtr
-	_, ok := dirtyHeaders[header]
+	_, ok := (*dirtyHeadersPtr)[sanitizeHeaderName(header)]
 	return ok
 }
 
-// UndirtHeader Remove a http header from the list of dirty header
-func UndirtHeader(req *http.Request, header string) {
-	header = sanitizeHeaderName(header)
-	dirtyHeadersPtr := DirtyHeaders(req)
+func undirty(dirtyHeadersPtr *map[string][]string, header string) {
 	if dirtyHeadersPtr == nil {
 		return
 	}
-	dirtyHeaders := *dirtyHeadersPtr
-	delete(dirtyHeaders, header)
-	*dirtyHeadersPtr = dirtyHeaders
+	delete(*dirtyHeadersPtr, sanitizeHeaderName(header))
 }
 
-// DirtyHeaders Retrieve all http headers marked as dirty
-func DirtyHeaders(req *http.Request) *map[string]string {
-	var dirtyHeaders *map[string]string
-	if err := InjectContextValue(req, dirtyHeadersKey, &dirtyHeaders); err != nil {
+func dirtyHeadersFromContext(req *http.Request, key GobisContextKey) *map[string][]string {
+	var dirtyHeaders *map[string][]string
+	if err := InjectContextValue(req, key, &dirtyHeaders); err != nil {
 		log.Errorf("got error when getting DirtyHeaders value: %s", err)
 	}
 
 	return dirtyHeaders
 }
 
+// cleanDirtyHeaders Drop or rewrite headers marked as dirty
+func cleanDirtyHeaders(headers http.Header, dirtyHeadersPtr *map[string][]string) {
+	if dirtyHeadersPtr == nil {
+		return
+	}
+	for header, values := range *dirtyHeadersPtr {
+		headers.Del(header)
+		for _, value := range values {
+			headers.Add(header, value)
+		}
+	}
+}
+
+// givenValues Values given by caller, a single empty value means no value (value of a header which was not set)
+func givenValues(values []string) []string {
+	if len(values) == 1 && values[0] == "" {
+		return nil
+	}
+	return cloneValues(values)
+}
+
+func cloneValues(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return append(make([]string, 0, len(values)), values...)
+}
+
 func sanitizeHeaderName(header string) string {
 	return strings.ToLower(strings.TrimSpace(header))
 }
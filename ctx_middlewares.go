@@ -2,41 +2,127 @@ package gobis
 
 import (
 	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
 	"net/http"
+	"time"
 )
 
 const (
+	// Deprecated: username and groups are now stored in Principal, use Groups function
+	// Groups are still stored under this key as a *map[string]bool, changing it doesn't change principal
 	GroupContextKey MiddlewareContextKey = iota
+	// Deprecated: username and groups are now stored in Principal, use Username function
+	// Username is still stored under this key as a *string, changing it doesn't change principal
 	UsernameContextKey
+	PrincipalContextKey
+	RolesContextKey
 )
 
 type MiddlewareContextKey int
 
+// Principal Identity of the user making the request, set by middlewares
+type Principal struct {
+	// Username Name of the user
+	Username string `json:"username"`
+	// UserID Unique id of the user
+	UserID string `json:"user_id,omitempty"`
+	// Email Email address of the user
+	Email string `json:"email,omitempty"`
+	// AuthMethod Method used to authenticate user, e.g.: basic, oauth2, client_cert
+	AuthMethod string `json:"auth_method,omitempty"`
+	// Groups User's groups
+	Groups []string `json:"groups"`
+	// Scopes Scopes granted to the token used by user
+	Scopes []string `json:"scopes,omitempty"`
+	// ExpiresAt Time when authentication expires
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// Claims Arbitrary claims about the user
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Claim Retrieve a claim by its name, user_id, email, auth_method, scopes and expires_at give principal fields
+// other names are looked up in Claims
+func (p Principal) Claim(name string) (interface{}, bool) {
+	switch name {
+	case "user_id":
+		return p.UserID, p.UserID != ""
+	case "email":
+		return p.Email, p.Email != ""
+	case "auth_method":
+		return p.AuthMethod, p.AuthMethod != ""
+	case "scopes":
+		return p.Scopes, len(p.Scopes) > 0
+	case "expires_at":
+		return p.ExpiresAt, !p.ExpiresAt.IsZero()
+	}
+	value, ok := p.Claims[name]
+	return value, ok
+}
+
+func (p *Principal) addGroups(groups ...string) {
+	for _, group := range groups {
+		if !funk.ContainsString(p.Groups, group) {
+			p.Groups = append(p.Groups, group)
+		}
+	}
+}
+
+// SetPrincipal Set the principal to a request context, it replaces username and groups already set
+func SetPrincipal(req *http.Request, principal Principal) {
+	p := principalPtr(req, true)
+	groups := principal.Groups
+	principal.Groups = make([]string, 0, len(groups))
+	principal.addGroups(groups...)
+	principal.Claims = copyClaims(principal.Claims)
+	*p = principal
+	syncDeprecatedValues(req, p)
+}
+
+// RequestPrincipal Retrieve a copy of the principal from a request context, groups, scopes and claims are copied too
+func RequestPrincipal(req *http.Request) Principal {
+	p := principalPtr(req, false)
+	if p == nil {
+		return Principal{Groups: make([]string, 0)}
+	}
+	principal := *p
+	principal.Groups = append(make([]string, 0, len(p.Groups)), p.Groups...)
+	principal.Scopes = append([]string(nil), p.Scopes...)
+	principal.Claims = copyClaims(p.Claims)
+	return principal
+}
+
+// SetClaim Set an arbitrary claim on principal in request context
+func SetClaim(req *http.Request, name string, value interface{}) {
+	p := principalPtr(req, true)
+	if p.Claims == nil {
+		p.Claims = make(map[string]interface{})
+	}
+	p.Claims[name] = value
+}
+
+// Claim Retrieve a claim from principal in request context, see Principal.Claim
+func Claim(req *http.Request, name string) (interface{}, bool) {
+	p := principalPtr(req, false)
+	if p == nil {
+		return nil, false
+	}
+	return p.Claim(name)
+}
+
 // SetUsername Set the username to a request context
 func SetUsername(req *http.Request, username string) {
-	userPtr := usernamePtr(req)
-	if userPtr == nil {
-		user := username
-		AddContextValue(req, UsernameContextKey, &user)
-		return
-	}
-	*userPtr = username
+	p := principalPtr(req, true)
+	p.Username = username
+	syncDeprecatedValues(req, p)
 }
 
 // Username Retrieve username from a request context
 func Username(req *http.Request) string {
-	userPtr := usernamePtr(req)
-	if userPtr == nil {
+	p := principalPtr(req, false)
+	if p == nil {
 		return ""
 	}
-	return *userPtr
-}
-func usernamePtr(req *http.Request) *string {
-	var username *string
-	if err := InjectContextValue(req, UsernameContextKey, &username); err != nil {
-		log.Errorf("got error when injecting context value: %s", err)
-	}
-	return username
+	return p.Username
 }
 
 // SetGroups add groups to a request context
@@ -50,45 +136,101 @@ func AddGroups(req *http.Request, groups ...string) {
 	if len(groups) == 0 {
 		return
 	}
-	groupsPtr := groupsPtr(req)
-	if groupsPtr == nil {
-		m := sliceToMap(groups)
-		AddContextValue(req, GroupContextKey, &m)
-		return
-	}
-	origGroups := *groupsPtr
-	for _, group := range groups {
-		origGroups[group] = true
-	}
-	*groupsPtr = origGroups
+	p := principalPtr(req, true)
+	p.addGroups(groups...)
+	syncDeprecatedValues(req, p)
 }
 
 // Groups retrieve groups from request context
 func Groups(req *http.Request) []string {
-	groupsPtr := groupsPtr(req)
-	if groupsPtr == nil {
+	p := principalPtr(req, false)
+	if p == nil {
 		return make([]string, 0)
 	}
-	return mapToSlice(*groupsPtr)
+	return append(make([]string, 0, len(p.Groups)), p.Groups...)
+}
+
+// Roles Retrieve roles expanded from user's groups with role mapping, they are not part of user's groups
+func Roles(req *http.Request) []string {
+	var roles *[]string
+	if err := InjectContextValue(req, RolesContextKey, &roles); err != nil {
+		log.Errorf("got error when injecting context value: %s", err)
+	}
+	if roles == nil {
+		return make([]string, 0)
+	}
+	return append(make([]string, 0, len(*roles)), *roles...)
+}
+
+func setRoles(req *http.Request, roles []string) {
+	AddContextValue(req, RolesContextKey, &roles)
+}
+
+// principalPtr Retrieve principal from request context, it is created when asked and not found
+func principalPtr(req *http.Request, create bool) *Principal {
+	var principal *Principal
+	if err := InjectContextValue(req, PrincipalContextKey, &principal); err != nil {
+		log.Errorf("got error when injecting context value: %s", err)
+	}
+	if principal == nil && create {
+		principal = &Principal{Groups: make([]string, 0)}
+		AddContextValue(req, PrincipalContextKey, principal)
+	}
+	return principal
 }
-func groupsPtr(req *http.Request) *map[string]bool {
-	var groups *map[string]bool
-	if err := InjectContextValue(req, GroupContextKey, &groups); err != nil {
+
+// syncDeprecatedValues Store username and groups of principal under deprecated UsernameContextKey and GroupContextKey
+// for middlewares still reading them
+func syncDeprecatedValues(req *http.Request, p *Principal) {
+	groups := make(map[string]bool, len(p.Groups))
+	for _, group := range p.Groups {
+		groups[group] = true
+	}
+	var username *string
+	var groupsPtr *map[string]bool
+	if err := InjectContextValue(req, UsernameContextKey, &username); err != nil {
+		log.Errorf("got error when injecting context value: %s", err)
+	}
+	if err := InjectContextValue(req, GroupContextKey, &groupsPtr); err != nil {
 		log.Errorf("got error when injecting context value: %s", err)
 	}
-	return groups
+	if username == nil {
+		user := p.Username
+		AddContextValue(req, UsernameContextKey, &user)
+	} else {
+		*username = p.Username
+	}
+	if groupsPtr == nil {
+		AddContextValue(req, GroupContextKey, &groups)
+	} else {
+		*groupsPtr = groups
+	}
 }
-func mapToSlice(m map[string]bool) []string {
-	s := make([]string, 0)
-	for key := range m {
-		s = append(s, key)
+
+// copyClaims Deep copy claims, nested maps and slices decoded from json are copied too
+func copyClaims(claims map[string]interface{}) map[string]interface{} {
+	if claims == nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(claims))
+	for name, value := range claims {
+		copied[name] = copyClaimValue(value)
 	}
-	return s
+	return copied
 }
-func sliceToMap(s []string) map[string]bool {
-	m := make(map[string]bool)
-	for _, v := range s {
-		m[v] = true
+
+func copyClaimValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyClaims(v)
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, elem := range v {
+			copied[i] = copyClaimValue(elem)
+		}
+		return copied
+	case []string:
+		return append([]string(nil), v...)
 	}
-	return m
+	return value
 }
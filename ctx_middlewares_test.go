@@ -0,0 +1,107 @@
+package gobis_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("CtxMiddlewares", func() {
+	var req *http.Request
+	BeforeEach(func() {
+		req = httptest.NewRequest("GET", "http://localhost/path", nil)
+	})
+	It("should give username and groups as views over principal", func() {
+		SetUsername(req, "alice")
+		AddGroups(req, "admin", "dev")
+		AddGroups(req, "admin")
+		principal := RequestPrincipal(req)
+		Expect(principal.Username).Should(Equal("alice"))
+		Expect(principal.Groups).Should(Equal([]string{"admin", "dev"}))
+
+		SetPrincipal(req, Principal{
+			Username:   "bob",
+			Email:      "bob@example.com",
+			AuthMethod: "oauth2",
+			Groups:     []string{"ops", "ops"},
+			Scopes:     []string{"read"},
+			ExpiresAt:  time.Now().Add(time.Hour),
+		})
+		Expect(Username(req)).Should(Equal("bob"))
+		Expect(Groups(req)).Should(Equal([]string{"ops"}))
+		email, ok := Claim(req, "email")
+		Expect(ok).Should(BeTrue())
+		Expect(email).Should(Equal("bob@example.com"))
+	})
+	It("should share principal with requests copied by middlewares", func() {
+		SetUsername(req, "alice")
+		copied := req.WithContext(req.Context())
+		SetClaim(copied, "tenant", "acme")
+		AddGroups(copied, "dev")
+		tenant, ok := Claim(req, "tenant")
+		Expect(ok).Should(BeTrue())
+		Expect(tenant).Should(Equal("acme"))
+		Expect(Groups(req)).Should(Equal([]string{"dev"}))
+	})
+	It("should still store username and groups under deprecated context keys", func() {
+		SetUsername(req, "alice")
+		AddGroups(req, "dev")
+		copied := req.WithContext(req.Context())
+		AddGroups(copied, "ops")
+		var username *string
+		var groups *map[string]bool
+		Expect(InjectContextValue(req, UsernameContextKey, &username)).To(Succeed())
+		Expect(InjectContextValue(req, GroupContextKey, &groups)).To(Succeed())
+		Expect(*username).Should(Equal("alice"))
+		Expect(*groups).Should(Equal(map[string]bool{"dev": true, "ops": true}))
+	})
+	It("should give a principal copy which doesn't change request principal", func() {
+		SetPrincipal(req, Principal{
+			Username: "alice",
+			Claims: map[string]interface{}{
+				"tenant": map[string]interface{}{"name": "acme"},
+				"roles":  []interface{}{"admin"},
+			},
+		})
+		principal := RequestPrincipal(req)
+		principal.Claims["tenant"].(map[string]interface{})["name"] = "other"
+		principal.Claims["roles"].([]interface{})[0] = "user"
+		principal.Claims["added"] = true
+		Expect(RequestPrincipal(req).Claims).Should(Equal(map[string]interface{}{
+			"tenant": map[string]interface{}{"name": "acme"},
+			"roles":  []interface{}{"admin"},
+		}))
+	})
+	It("should forward selected claims to upstream", func() {
+		var receivedHeaders http.Header
+		factory := NewRouterFactory().(*RouterFactoryService)
+		factory.IdentityHeaders = IdentityHeaders{
+			ClaimHeaders: map[string]string{
+				"email":   "X-Gobis-Email",
+				"scopes":  "X-Gobis-Scopes",
+				"missing": "X-Gobis-Missing",
+			},
+		}
+		handler, err := factory.CreateForwardHandler(ProxyRoute{
+			Name: "app",
+			Path: NewPathMatcher("/**"),
+			ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				receivedHeaders = r.Header.Clone()
+			}),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("X-Gobis-Missing", "forged")
+		SetPrincipal(req, Principal{
+			Username: "alice",
+			Email:    "alice@example.com",
+			Scopes:   []string{"read", "write"},
+		})
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(receivedHeaders.Get("X-Gobis-Email")).Should(Equal("alice@example.com"))
+		Expect(receivedHeaders.Get("X-Gobis-Scopes")).Should(Equal(`["read","write"]`))
+		Expect(receivedHeaders).ShouldNot(HaveKey("X-Gobis-Missing"))
+	})
+})
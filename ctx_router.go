@@ -8,6 +8,8 @@ import (
 const (
 	pathContextKey RouterContextKey = iota
 	routeNameContextKey
+	clientIPContextKey
+	requestPathContextKey
 )
 
 type RouterContextKey int
@@ -38,3 +40,35 @@ func RouteName(req *http.Request) string {
 	}
 	return routeName
 }
+
+// SetClientIP Set the real ip address of the client to a request context (used by handler when resolving trusted proxies)
+func SetClientIP(req *http.Request, ip string) {
+	AddContextValue(req, clientIPContextKey, ip)
+}
+
+// ClientIP Retrieve real ip address of the client, forwarding headers sent by trusted proxies are taken into account
+// If not set by handler, ip address from request remote address is given
+func ClientIP(req *http.Request) string {
+	var ip string
+	if err := InjectContextValue(req, clientIPContextKey, &ip); err != nil {
+		log.Errorf("got error when injecting context value: %s", err)
+	}
+	if ip == "" {
+		return remoteIP(req)
+	}
+	return ip
+}
+
+// setRequestPath Keep path requested by client before it is rewritten to be sent to upstream
+func setRequestPath(req *http.Request) {
+	AddContextValue(req, requestPathContextKey, req.URL.Path)
+}
+
+// requestPath Retrieve path requested by client, current path is returned when request has not been forwarded
+func requestPath(req *http.Request) string {
+	path, ok := req.Context().Value(requestPathContextKey).(string)
+	if !ok {
+		return req.URL.Path
+	}
+	return path
+}
@@ -1,10 +1,13 @@
 package gobis
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"net/http"
+	"os"
 )
 
 type DefaultHandlerConfig struct {
@@ -14,6 +17,55 @@ type DefaultHandlerConfig struct {
 	ProtectedHeaders []string `json:"protected_headers" yaml:"protected_headers"`
 	// Set the path where all path from routes should start (e.g.: if set to `/root` request for the next routes will be localhost/root/app)
 	StartPath string `json:"start_path" yaml:"start_path"`
+	// PACFile Path to a local PAC file used by all routes which doesn't set their own pac file or pac script
+	// Routes setting resolve don't use it, it is compiled once and shared by routes
+	PACFile string `json:"pac_file" yaml:"pac_file"`
+	// PACScript Content of a PAC file used by all routes which doesn't set their own pac file or pac script
+	// Routes setting resolve don't use it
+	PACScript string `json:"pac_script" yaml:"pac_script"`
+	// EnabledMiddlewares Middlewares to create from a registry with their global options when using NewDefaultHandlerFromRegistry
+	EnabledMiddlewares []EnabledMiddleware `json:"enabled_middlewares" yaml:"enabled_middlewares"`
+	// Middlewares Names of middlewares used by routes which doesn't set their own middlewares option, in the order they are called
+	// (Default: all middlewares given to handler in their order)
+	Middlewares []string `json:"middlewares" yaml:"middlewares"`
+	// StrictMiddlewareParams Set to true to refuse params of routes which are not used by any of their middlewares
+	// and params in params_by_middleware which are not used by their middleware
+	StrictMiddlewareParams bool `json:"strict_middleware_params" yaml:"strict_middleware_params"`
+	// DnsCacheTTL Cache upstream hosts resolution for this duration, cache is shared by all routes (Default: no cache)
+	DnsCacheTTL Duration `json:"dns_cache_ttl" yaml:"dns_cache_ttl"`
+	// DnsCacheMaxEntries Maximum number of hosts kept in dns cache (Default: 10000)
+	DnsCacheMaxEntries int `json:"dns_cache_max_entries" yaml:"dns_cache_max_entries"`
+	// TrustedProxies List of ip addresses or cidrs (e.g.: 10.0.0.0/8) of proxies in front of gobis
+	// Forwarding headers (X-Forwarded-*, X-Real-Ip and Forwarded) are reset when request doesn't come from one of them
+	// and real client ip is resolved from X-Forwarded-For or Forwarded header (Default: all proxies are trusted)
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+	// IdentitySources Headers set by trusted components in front of gobis (sso proxy, mtls terminator, ...)
+	// used to set username and groups before middlewares are called
+	IdentitySources []IdentitySource `json:"identity_sources" yaml:"identity_sources"`
+	// RoleMapping Expand user's groups into roles, key is a group (glob patterns are allowed) and value a list of roles
+	// Roles are expanded after middlewares and are checked with groups by allowed_groups and denied_groups of routes
+	// They are sent to upstream separately from groups (see IdentityHeaders.RolesHeader)
+	RoleMapping RoleMapping `json:"role_mapping" yaml:"role_mapping"`
+	// PolicyFile Path to a yaml or json file containing a policy used by routes which doesn't set their own policy or policy_file
+	PolicyFile string `json:"policy_file" yaml:"policy_file"`
+	// AuditLogFile Path to a file where policy decisions are appended as json lines (Default: decisions are written in standard logs)
+	AuditLogFile string `json:"audit_log_file" yaml:"audit_log_file"`
+	// ClientCert Authenticate clients with certificates, tls listener must use tls config given by DefaultHandler.TLSConfig
+	// Use option client_cert on routes to make a certificate required or to ignore it
+	ClientCert *ClientCertConfig `json:"client_cert" yaml:"client_cert"`
+	// IdentityHeaders Headers used to send username and groups set by middlewares to upstream
+	// Inbound X-Gobis-* headers and these headers are always removed from client requests
+	IdentityHeaders IdentityHeaders `json:"identity_headers" yaml:"identity_headers"`
+	// IdentityToken Send a signed JWT containing username, groups, route name and request id to upstream
+	// Public key is served as a jwks to let upstream validate tokens
+	IdentityToken *IdentityTokenConfig `json:"identity_token" yaml:"identity_token"`
+	// ErrorFormat Format of error responses generated by gobis: json, problem (RFC 7807) or html (Default: json)
+	// Routes can set their own error_format
+	ErrorFormat string `json:"error_format" yaml:"error_format"`
+	// ErrorTemplateFile Path to an html template used to render errors when error format is html (see ErrorData)
+	ErrorTemplateFile string `json:"error_template_file" yaml:"error_template_file"`
+	// SendForwardedHeader Set to true to send standard Forwarded header (RFC 7239) to upstream in addition to X-Forwarded-* headers
+	SendForwardedHeader bool `json:"send_forwarded_header" yaml:"send_forwarded_header"`
 }
 
 type MiddlewareConfig struct {
@@ -24,9 +76,14 @@ type MiddlewareConfig struct {
 }
 
 type DefaultHandler struct {
-	port      int
-	host      string
-	muxRouter *mux.Router
+	port            int
+	host            string
+	muxRouter       *mux.Router
+	trustedProxies  trustedProxies
+	tokenSigner     *IdentityTokenSigner
+	identitySources identitySources
+	factory         *RouterFactoryService
+	auditLogFile    io.Closer
 }
 
 func NewHandler(routes []ProxyRoute, middlewareHandlers ...MiddlewareHandler) (GobisHandler, error) {
@@ -35,35 +92,119 @@ func NewHandler(routes []ProxyRoute, middlewareHandlers ...MiddlewareHandler) (G
 	}, middlewareHandlers...)
 }
 
+// NewHandlerWithFactory Create a handler with routes created by factory
+// Factory is given by caller, it is not closed when an error occurs and caller must close it
 func NewHandlerWithFactory(routes []ProxyRoute, factory RouterFactory) (GobisHandler, error) {
 	config := DefaultHandlerConfig{
 		Routes: routes,
 	}
-	SetProtectedHeaders(config.ProtectedHeaders)
+	factoryService, _ := factory.(*RouterFactoryService)
 	muxRouter, err := generateMuxRouter(config, factory)
 	if err != nil {
 		return nil, err
 	}
+	var errorHandler ErrorHandler = JsonErrorHandler{}
+	if factoryService != nil && factoryService.ErrorHandler != nil {
+		errorHandler = factoryService.ErrorHandler
+	}
+	muxRouter.NotFoundHandler = notFoundHandler(errorHandler)
 	return &DefaultHandler{
 		muxRouter: muxRouter,
+		factory:   factoryService,
 	}, nil
 }
 
 func NewDefaultHandler(config DefaultHandlerConfig, middlewareHandlers ...MiddlewareHandler) (GobisHandler, error) {
-	SetProtectedHeaders(config.ProtectedHeaders)
-	muxRouter, err := generateMuxRouter(config, NewRouterFactory(middlewareHandlers...))
+	proxies, err := parseTrustedProxies(config.TrustedProxies)
 	if err != nil {
 		return nil, err
 	}
-	return &DefaultHandler{
-		muxRouter: muxRouter,
-	}, nil
+	srcs, err := newIdentitySources(config.IdentitySources)
+	if err != nil {
+		return nil, err
+	}
+	factory := NewRouterFactory(middlewareHandlers...).(*RouterFactoryService)
+	factory.TransportOptions, err = transportOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	factory.IdentityHeaders = config.IdentityHeaders
+	factory.RoleMapping = config.RoleMapping
+	factory.StrictParams = config.StrictMiddlewareParams
+	factory.ErrorHandler, err = NewErrorHandler(config.ErrorFormat, config.ErrorTemplateFile)
+	if err != nil {
+		return nil, err
+	}
+	if config.ClientCert != nil {
+		factory.ClientCertMapper, err = NewClientCertMapper(*config.ClientCert)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.IdentityToken != nil {
+		factory.IdentityTokenSigner, err = NewIdentityTokenSigner(*config.IdentityToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+	handler := &DefaultHandler{
+		trustedProxies:  proxies,
+		tokenSigner:     factory.IdentityTokenSigner,
+		identitySources: srcs,
+		factory:         factory,
+	}
+	if config.AuditLogFile != "" {
+		factory.AuditLogger, err = newAuditLogger(config.AuditLogFile)
+		if err != nil {
+			return nil, err
+		}
+		handler.auditLogFile, _ = factory.AuditLogger.Out.(io.Closer)
+	}
+	handler.muxRouter, err = generateMuxRouter(config, factory)
+	if err != nil {
+		//nolint:errcheck
+		handler.Close()
+		return nil, err
+	}
+	handler.muxRouter.NotFoundHandler = notFoundHandler(factory.ErrorHandler)
+	return handler, nil
 }
 
 func (h *DefaultHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.tokenSigner != nil && req.Method == http.MethodGet && req.URL.Path == h.tokenSigner.JwksPath() {
+		h.tokenSigner.ServeHTTP(w, req)
+		return
+	}
+	h.trustedProxies.handleForwardingHeaders(req)
+	h.identitySources.apply(req)
 	h.muxRouter.ServeHTTP(w, req)
 }
 
+// Close Close handlers created by middlewares, middlewares and shared resources implementing Closer and audit log file
+// Handler must not be used after
+func (h *DefaultHandler) Close() error {
+	var firstErr error
+	if h.factory != nil {
+		firstErr = h.factory.Close()
+	}
+	if h.auditLogFile != nil {
+		if err := h.auditLogFile.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		h.auditLogFile = nil
+	}
+	return firstErr
+}
+
+// TLSConfig Create a tls config requesting and verifying client certificates when client_cert is set, nil is returned otherwise
+// Server certificates must be added to returned config before using it on a tls listener
+func (h *DefaultHandler) TLSConfig() *tls.Config {
+	if h.factory == nil || h.factory.ClientCertMapper == nil {
+		return nil
+	}
+	return h.factory.ClientCertMapper.TLSConfig()
+}
+
 func (h DefaultHandler) GetServerAddr() string {
 	port := h.port
 	if port == 0 {
@@ -80,7 +221,7 @@ func generateMuxRouter(config DefaultHandlerConfig, routerFactory RouterFactory)
 	var err error
 	var rtr *mux.Router
 	log.Debug("orange-cloudfoundry/gobis/handlers: Creating mux router for routes ...")
-	rtr, err = routerFactory.CreateMuxRouter(config.Routes, config.StartPath)
+	rtr, err = routerFactory.CreateMuxRouter(applyHandlerDefaults(config, config.Routes), config.StartPath)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +229,55 @@ func generateMuxRouter(config DefaultHandlerConfig, routerFactory RouterFactory)
 	return rtr, nil
 }
 
+// transportOptions Create route transport options from handler level options
+func transportOptions(config DefaultHandlerConfig) ([]RouteTransportOption, error) {
+	opts := []RouteTransportOption{WithProtectedHeaders(config.ProtectedHeaders...)}
+	if config.PACFile != "" || config.PACScript != "" {
+		pac, err := newPacResolver(config.PACFile, config.PACScript)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pac: %s", err.Error())
+		}
+		opts = append(opts, withPacResolver(pac))
+	}
+	if config.DnsCacheTTL > 0 {
+		opts = append(opts, WithDnsCache(NewDnsCacheWithMaxEntries(config.DnsCacheTTL.Duration(), config.DnsCacheMaxEntries)))
+	}
+	if config.SendForwardedHeader {
+		opts = append(opts, WithForwardedHeader())
+	}
+	return opts, nil
+}
+
+// applyHandlerDefaults Set handler level options on routes which doesn't set their own
+func applyHandlerDefaults(config DefaultHandlerConfig, routes []ProxyRoute) []ProxyRoute {
+	finalRoutes := make([]ProxyRoute, len(routes))
+	for i, route := range routes {
+		if route.Middlewares == nil && config.Middlewares != nil {
+			route.Middlewares = config.Middlewares
+		}
+		if route.Policy == nil && route.PolicyFile == "" {
+			route.PolicyFile = config.PolicyFile
+		}
+		if len(route.Routes) > 0 {
+			route.Routes = applyHandlerDefaults(config, route.Routes)
+		}
+		finalRoutes[i] = route
+	}
+	return finalRoutes
+}
+
+// newAuditLogger Create a logger writing json lines in a file
+func newAuditLogger(path string) (*log.Logger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log file: %s", err.Error())
+	}
+	logger := log.New()
+	logger.SetOutput(f)
+	logger.SetFormatter(&log.JSONFormatter{})
+	return logger, nil
+}
+
 func NewGobisMiddleware(routes []ProxyRoute, middlewareHandlers ...MiddlewareHandler) (func(next http.Handler) http.Handler, error) {
 	log.Debug("orange-cloudfoundry/gobis/middleware: Creating mux router for routes ...")
 	rtr, err := NewRouterFactory(middlewareHandlers...).CreateMuxRouter(routes, "")
@@ -0,0 +1,82 @@
+package gobis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// dirtyResponseWriter Drop or rewrite response headers marked as dirty by middlewares before sending them to client
+type dirtyResponseWriter struct {
+	http.ResponseWriter
+	dirtyHeaders *map[string][]string
+	wroteHeader  bool
+}
+
+// newDirtyResponseWriter Wrap a response writer, request context is initialized to let middlewares mark headers
+// even if they pass a copy of the request to the next handler
+func newDirtyResponseWriter(w http.ResponseWriter, req *http.Request) *dirtyResponseWriter {
+	dirtyHeaders := DirtyResponseHeaders(req)
+	if dirtyHeaders == nil {
+		dirtyHeaders = &map[string][]string{}
+		AddContextValue(req, dirtyResponseHeadersKey, dirtyHeaders)
+	}
+	return &dirtyResponseWriter{
+		ResponseWriter: w,
+		dirtyHeaders:   dirtyHeaders,
+	}
+}
+
+func (w *dirtyResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		cleanDirtyHeaders(w.Header(), w.dirtyHeaders)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *dirtyResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *dirtyResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// ReadFrom Keep sendfile optimization of underlying response writer when copying a response body
+func (w *dirtyResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+func (w *dirtyResponseWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+func (w *dirtyResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer doesn't support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *dirtyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,98 @@
+package gobis
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultDnsCacheMaxEntries = 10000
+
+type dnsCacheEntry struct {
+	addrs  []string
+	expire time.Time
+}
+
+// DnsCache A dns cache shared by all routes of a handler to not resolve upstream hosts on every dial
+// When cache is full expired entries are removed, if there is none a random entry is removed
+type DnsCache struct {
+	ttl        time.Duration
+	maxEntries int
+	resolver   *net.Resolver
+	mu         sync.RWMutex
+	entries    map[string]dnsCacheEntry
+}
+
+func NewDnsCache(ttl time.Duration) *DnsCache {
+	return NewDnsCacheWithMaxEntries(ttl, defaultDnsCacheMaxEntries)
+}
+
+// NewDnsCacheWithMaxEntries Create a dns cache keeping at most maxEntries hosts (Default: 10000 when maxEntries <= 0)
+func NewDnsCacheWithMaxEntries(ttl time.Duration, maxEntries int) *DnsCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultDnsCacheMaxEntries
+	}
+	return &DnsCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		resolver:   net.DefaultResolver,
+		entries:    make(map[string]dnsCacheEntry),
+	}
+}
+
+// LookupHost Retrieve addresses of a host from cache or resolve them when not found or expired
+func (c *DnsCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	c.mu.RLock()
+	entry, ok := c.entries[host]
+	c.mu.RUnlock()
+	if ok && time.Now().Before(entry.expire) {
+		return entry.addrs, nil
+	}
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if _, exists := c.entries[host]; !exists && len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+	c.entries[host] = dnsCacheEntry{
+		addrs:  addrs,
+		expire: time.Now().Add(c.ttl),
+	}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// Len Number of hosts in cache, expired entries included
+func (c *DnsCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// evict Remove expired entries or a random one when there is none, lock must be held
+func (c *DnsCache) evict() {
+	now := time.Now()
+	for host, entry := range c.entries {
+		if now.After(entry.expire) {
+			delete(c.entries, host)
+		}
+	}
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	// map iteration order is random
+	for host := range c.entries {
+		delete(c.entries, host)
+		return
+	}
+}
+
+// Flush Remove all entries from cache
+func (c *DnsCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]dnsCacheEntry)
+}
@@ -0,0 +1,240 @@
+package gobis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"os"
+)
+
+type ErrorKind string
+
+const (
+	// ErrorKindUpstream Upstream can't be reached or its response can't be read
+	ErrorKindUpstream ErrorKind = "upstream"
+	// ErrorKindNoRoute No route matches request
+	ErrorKindNoRoute ErrorKind = "no_route"
+	// ErrorKindDenied Request is refused by client certificate, groups or policy checks
+	ErrorKindDenied ErrorKind = "denied"
+	// ErrorKindPanic A panic occurred when serving request
+	ErrorKindPanic ErrorKind = "panic"
+	// ErrorKindInternal Request can't be forwarded because of an internal error (e.g.: identity token can't be signed)
+	ErrorKindInternal ErrorKind = "internal"
+)
+
+const (
+	// ErrorFormatJson Errors are written as JsonError (default)
+	ErrorFormatJson = "json"
+	// ErrorFormatProblem Errors are written as problem details (RFC 7807) with content type application/problem+json
+	ErrorFormatProblem = "problem"
+	// ErrorFormatHtml Errors are written as an html page
+	ErrorFormatHtml = "html"
+)
+
+// statusClientClosedRequest Non-standard status used when client closed request before upstream answered
+const statusClientClosedRequest = 499
+
+// GobisError Error generated by gobis, it is the cause given to error handlers
+type GobisError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *GobisError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *GobisError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler Write response for errors generated by gobis
+// Route is empty when error is of kind no_route, cause is always a *GobisError
+type ErrorHandler interface {
+	ServeError(w http.ResponseWriter, req *http.Request, route ProxyRoute, status int, cause error)
+}
+
+type ErrorHandlerFunc func(w http.ResponseWriter, req *http.Request, route ProxyRoute, status int, cause error)
+
+func (f ErrorHandlerFunc) ServeError(w http.ResponseWriter, req *http.Request, route ProxyRoute, status int, cause error) {
+	f(w, req, route, status, cause)
+}
+
+// NewErrorHandler Create a built-in error handler from its format: json, problem or html
+// templateFile is an html template used by html format (Default: a simple page), it receives an ErrorData
+func NewErrorHandler(format string, templateFile string) (ErrorHandler, error) {
+	switch format {
+	case "", ErrorFormatJson:
+		return JsonErrorHandler{}, nil
+	case ErrorFormatProblem:
+		return ProblemErrorHandler{}, nil
+	case ErrorFormatHtml:
+		if templateFile == "" {
+			return NewHtmlErrorHandler(defaultErrorTemplate)
+		}
+		b, err := os.ReadFile(templateFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read error template file: %s", err.Error())
+		}
+		return NewHtmlErrorHandler(string(b))
+	}
+	return nil, fmt.Errorf("unknown error format '%s', must be one of json, problem or html", format)
+}
+
+// ErrorData Data describing an error given to html templates
+type ErrorData struct {
+	Status    int
+	Title     string
+	Details   string
+	RouteName string
+	Kind      ErrorKind
+}
+
+func newErrorData(route ProxyRoute, status int, cause error) ErrorData {
+	data := ErrorData{
+		Status:    status,
+		Title:     statusText(status),
+		Details:   statusText(status),
+		RouteName: route.Name,
+	}
+	var gobisErr *GobisError
+	if errors.As(cause, &gobisErr) {
+		data.Kind = gobisErr.Kind
+	}
+	// errors from upstream, panics and internal errors can leak internal information
+	if route.ShowError || (data.Kind != ErrorKindUpstream && data.Kind != ErrorKindPanic && data.Kind != ErrorKindInternal) {
+		data.Details = cause.Error()
+	}
+	return data
+}
+
+func statusText(status int) string {
+	if status == statusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+// JsonErrorHandler Write errors as JsonError
+type JsonErrorHandler struct{}
+
+func (JsonErrorHandler) ServeError(w http.ResponseWriter, _ *http.Request, route ProxyRoute, status int, cause error) {
+	data := newErrorData(route, status, cause)
+	writeJsonError(w, status, route.Name, data.Details)
+}
+
+// ProblemDetails Problem details for http apis (RFC 7807)
+type ProblemDetails struct {
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	Instance  string    `json:"instance,omitempty"`
+	RouteName string    `json:"route_name,omitempty"`
+	Kind      ErrorKind `json:"kind,omitempty"`
+}
+
+// ProblemErrorHandler Write errors as problem details (RFC 7807) with content type application/problem+json
+type ProblemErrorHandler struct{}
+
+func (ProblemErrorHandler) ServeError(w http.ResponseWriter, req *http.Request, route ProxyRoute, status int, cause error) {
+	data := newErrorData(route, status, cause)
+	problem := ProblemDetails{
+		Type:      "about:blank",
+		Title:     data.Title,
+		Status:    status,
+		Detail:    data.Details,
+		Instance:  requestPath(req),
+		RouteName: route.Name,
+		Kind:      data.Kind,
+	}
+	b, _ := json.MarshalIndent(problem, "", "\t")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		log.Errorf("write failed: %s", err.Error())
+	}
+}
+
+const defaultErrorTemplate = `<!DOCTYPE html>
+<html>
+<head><title>{{ .Status }} {{ .Title }}</title></head>
+<body>
+<h1>{{ .Status }} {{ .Title }}</h1>
+<p>{{ .Details }}</p>
+</body>
+</html>
+`
+
+// HtmlErrorHandler Write errors as an html page rendered from a template receiving an ErrorData
+type HtmlErrorHandler struct {
+	template *template.Template
+}
+
+func NewHtmlErrorHandler(tmpl string) (*HtmlErrorHandler, error) {
+	t, err := template.New("error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error template: %s", err.Error())
+	}
+	return &HtmlErrorHandler{template: t}, nil
+}
+
+func (h *HtmlErrorHandler) ServeError(w http.ResponseWriter, _ *http.Request, route ProxyRoute, status int, cause error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.template.Execute(w, newErrorData(route, status, cause)); err != nil {
+		log.WithField("route_name", route.Name).Errorf("orange-cloudfoundry/gobis/proxy: error when rendering error page: %s", err.Error())
+	}
+}
+
+// upstreamErrorStatus Find status to answer when an error occurred when calling upstream
+func upstreamErrorStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusBadGateway
+	case errors.Is(err, io.EOF):
+		return http.StatusBadGateway
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// routeErrorHandler Find error handler of a route: handler set on route, then route error format and finally factory error handler
+func (r RouterFactoryService) routeErrorHandler(proxyRoute ProxyRoute) (ErrorHandler, error) {
+	if proxyRoute.ErrorHandler != nil {
+		return proxyRoute.ErrorHandler, nil
+	}
+	if proxyRoute.ErrorFormat != "" {
+		handler, err := NewErrorHandler(proxyRoute.ErrorFormat, proxyRoute.ErrorTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("route '%s': %s", proxyRoute.Name, err.Error())
+		}
+		return handler, nil
+	}
+	if r.ErrorHandler != nil {
+		return r.ErrorHandler, nil
+	}
+	return JsonErrorHandler{}, nil
+}
+
+func serveError(errorHandler ErrorHandler, w http.ResponseWriter, req *http.Request, route ProxyRoute, status int, kind ErrorKind, err error) {
+	errorHandler.ServeError(w, req, route, status, &GobisError{Kind: kind, Err: err})
+}
+
+// notFoundHandler Answer with error handler when no route matches request
+func notFoundHandler(errorHandler ErrorHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		serveError(errorHandler, w, req, ProxyRoute{}, http.StatusNotFound, ErrorKindNoRoute, fmt.Errorf("no route matches path '%s'", req.URL.Path))
+	})
+}
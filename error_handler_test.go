@@ -0,0 +1,173 @@
+package gobis_test
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"io"
+	"net/http"
+	"net/http/httptest"
+)
+
+type panicMiddleware struct{}
+
+func (panicMiddleware) Handler(_ ProxyRoute, _ interface{}, _ http.Handler) (http.Handler, error) {
+	return http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		panic("middleware failure")
+	}), nil
+}
+
+func (panicMiddleware) Schema() interface{} {
+	return struct{}{}
+}
+
+var _ = Describe("ErrorHandler", func() {
+	var closedUrl string
+	BeforeEach(func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+		closedUrl = backend.URL
+		backend.Close()
+	})
+	serve := func(handler http.Handler, path string) *http.Response {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost"+path, nil))
+		return recorder.Result()
+	}
+	Context("upstream errors", func() {
+		It("should write problem details without error details when upstream can't be reached", func() {
+			for _, noBuffer := range []bool{false, true} {
+				handler, err := NewHandler([]ProxyRoute{
+					{
+						Name:        "app",
+						Path:        NewPathMatcher("/app/**"),
+						Url:         closedUrl,
+						NoProxy:     true,
+						NoBuffer:    noBuffer,
+						ErrorFormat: ErrorFormatProblem,
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				resp := serve(handler, "/app/path")
+				Expect(resp.StatusCode).Should(Equal(http.StatusBadGateway))
+				Expect(resp.Header.Get("Content-Type")).Should(Equal("application/problem+json"))
+
+				var problem ProblemDetails
+				Expect(json.NewDecoder(resp.Body).Decode(&problem)).To(Succeed())
+				Expect(problem.Status).Should(Equal(http.StatusBadGateway))
+				Expect(problem.Title).Should(Equal("Bad Gateway"))
+				Expect(problem.Detail).Should(Equal("Bad Gateway"))
+				Expect(problem.Instance).Should(Equal("/app/path"))
+				Expect(problem.RouteName).Should(Equal("app"))
+				Expect(problem.Kind).Should(Equal(ErrorKindUpstream))
+			}
+		})
+		It("should show error details when route set show error", func() {
+			handler, err := NewHandler([]ProxyRoute{
+				{
+					Name:      "app",
+					Path:      NewPathMatcher("/app/**"),
+					Url:       closedUrl,
+					NoProxy:   true,
+					ShowError: true,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			resp := serve(handler, "/app/path")
+			Expect(resp.StatusCode).Should(Equal(http.StatusBadGateway))
+
+			var jsonErr JsonError
+			Expect(json.NewDecoder(resp.Body).Decode(&jsonErr)).To(Succeed())
+			Expect(jsonErr.Details).Should(ContainSubstring("connection refused"))
+		})
+	})
+	Context("no route", func() {
+		It("should render errors with format of handler", func() {
+			handler, err := NewDefaultHandler(DefaultHandlerConfig{
+				ErrorFormat: ErrorFormatHtml,
+				Routes: []ProxyRoute{
+					{
+						Name: "app",
+						Path: NewPathMatcher("/app/**"),
+						Url:  "http://localhost",
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			resp := serve(handler, "/unknown")
+			Expect(resp.StatusCode).Should(Equal(http.StatusNotFound))
+			Expect(resp.Header.Get("Content-Type")).Should(Equal("text/html; charset=utf-8"))
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).Should(ContainSubstring("<h1>404 Not Found</h1>"))
+			Expect(string(body)).Should(ContainSubstring("no route matches path &#39;/unknown&#39;"))
+		})
+		It("should refuse unknown error format", func() {
+			_, err := NewDefaultHandler(DefaultHandlerConfig{ErrorFormat: "xml"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("denied and panic", func() {
+		var kinds []ErrorKind
+		var errorHandler ErrorHandler
+		BeforeEach(func() {
+			kinds = make([]ErrorKind, 0)
+			errorHandler = ErrorHandlerFunc(func(w http.ResponseWriter, _ *http.Request, route ProxyRoute, status int, cause error) {
+				var gobisErr *GobisError
+				Expect(errors.As(cause, &gobisErr)).Should(BeTrue())
+				kinds = append(kinds, gobisErr.Kind)
+				w.Header().Set("X-Route", route.Name)
+				w.WriteHeader(status)
+			})
+		})
+		It("should give denied requests to error handler of route", func() {
+			handler, err := NewHandler([]ProxyRoute{
+				{
+					Name:          "app",
+					Path:          NewPathMatcher("/app/**"),
+					Url:           "http://localhost",
+					AllowedGroups: []string{"admin"},
+					ErrorHandler:  errorHandler,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			resp := serve(handler, "/app/path")
+			Expect(resp.StatusCode).Should(Equal(http.StatusUnauthorized))
+			Expect(resp.Header.Get("X-Route")).Should(Equal("app"))
+			Expect(kinds).Should(Equal([]ErrorKind{ErrorKindDenied}))
+		})
+		It("should give panics to error handler of factory", func() {
+			factory := NewRouterFactory(&panicMiddleware{}).(*RouterFactoryService)
+			factory.ErrorHandler = errorHandler
+			handler, err := NewHandlerWithFactory([]ProxyRoute{
+				{
+					Name: "app",
+					Path: NewPathMatcher("/app/**"),
+					Url:  "http://localhost",
+				},
+			}, factory)
+			Expect(err).NotTo(HaveOccurred())
+			resp := serve(handler, "/app/path")
+			Expect(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+			Expect(kinds).Should(Equal([]ErrorKind{ErrorKindPanic}))
+
+			resp = serve(handler, "/unknown")
+			Expect(resp.StatusCode).Should(Equal(http.StatusNotFound))
+			Expect(resp.Header.Get("X-Route")).Should(BeEmpty())
+			Expect(kinds).Should(Equal([]ErrorKind{ErrorKindPanic, ErrorKindNoRoute}))
+		})
+	})
+	Context("ProxyRoute", func() {
+		It("should refuse unknown error format", func() {
+			err := ProxyRoute{
+				Name:        "app",
+				Path:        NewPathMatcher("/app/**"),
+				Url:         "http://localhost",
+				ErrorFormat: "xml",
+			}.Check()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("invalid error_format"))
+		})
+	})
+})
@@ -0,0 +1,16 @@
+package gobis
+
+import "sort"
+
+// ProtectedHeaders Retrieve package level list of headers which cannot be removed by sensitive headers, headers are lowercased
+// It is only exported for tests to restore package level state
+func ProtectedHeaders() []string {
+	protectedHeadersMu.RLock()
+	defer protectedHeadersMu.RUnlock()
+	headers := make([]string, 0, len(protectedHeaders))
+	for header := range protectedHeaders {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	return headers
+}
@@ -0,0 +1,217 @@
+package gobis
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/utils"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+type ForwardProxyConfig struct {
+	// Name of the forward proxy, it is used as route name for logs and middlewares
+	Name string `json:"name" yaml:"name"`
+	// AllowedHosts List of destination hosts which can be reached through the proxy
+	// Wildcard are allowed, e.g.: *.my.domain.com
+	// If empty no destination can be reached
+	AllowedHosts HostMatchers `json:"allowed_hosts" yaml:"allowed_hosts"`
+	// AllowedPorts List of destination ports which can be reached through the proxy (Default: all ports are accepted)
+	AllowedPorts []int `json:"allowed_ports" yaml:"allowed_ports"`
+	// AllowedGroups Only requests having one of these groups (set by middlewares with gobis.AddGroups) can use the proxy
+	// If empty, groups are not checked
+	AllowedGroups []string `json:"allowed_groups" yaml:"allowed_groups"`
+	// MiddlewareParams It was made to pass arbitrary params to use it after in gobis middlewares
+	// This can be a structure (to set them programmatically) or a map[string]interface{} (to set them from a config file)
+	MiddlewareParams interface{} `json:"middleware_params" yaml:"middleware_params"`
+	// IdleTimeout Close tunnels created with CONNECT when no data has been exchanged during this time (default: no timeout)
+	IdleTimeout Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	// ShowError Set to true to see errors on web page when there is a panic error on gobis
+	ShowError bool `json:"show_error" yaml:"show_error"`
+	// ErrorFormat Format of error responses generated by the proxy: json, problem (RFC 7807) or html (Default: json)
+	ErrorFormat string `json:"error_format" yaml:"error_format"`
+	// ErrorTemplateFile Path to an html template used to render errors when error format is html (see ErrorData)
+	ErrorTemplateFile string `json:"error_template_file" yaml:"error_template_file"`
+	// ErrorHandler Set an error handler to render errors of the proxy when using gobis programmatically
+	// This override ErrorFormat
+	ErrorHandler ErrorHandler `json:"-" yaml:"-"`
+}
+
+// ForwardProxyHandler An egress proxy which accept absolute-uri requests and CONNECT tunnels
+// Requests pass through middlewares before reaching their destination
+type ForwardProxyHandler struct {
+	config       ForwardProxyConfig
+	route        ProxyRoute
+	handler      http.Handler
+	fwd          *forward.Forwarder
+	factory      *RouterFactoryService
+	errorHandler ErrorHandler
+}
+
+func NewForwardProxyHandler(config ForwardProxyConfig, middlewareHandlers ...MiddlewareHandler) (*ForwardProxyHandler, error) {
+	if config.Name == "" {
+		config.Name = "forward-proxy"
+	}
+	h := &ForwardProxyHandler{
+		config: config,
+		route: ProxyRoute{
+			Name:              config.Name,
+			Path:              NewPathMatcher("/**"),
+			MiddlewareParams:  config.MiddlewareParams,
+			ShowError:         config.ShowError,
+			ErrorFormat:       config.ErrorFormat,
+			ErrorTemplateFile: config.ErrorTemplateFile,
+			ErrorHandler:      config.ErrorHandler,
+		},
+	}
+	h.factory = NewRouterFactory(middlewareHandlers...).(*RouterFactoryService)
+	var err error
+	h.errorHandler, err = h.factory.routeErrorHandler(h.route)
+	if err != nil {
+		return nil, err
+	}
+	h.fwd, err = forward.New(
+		forward.RoundTripper(NewDefaultTransport()),
+		forward.Rewriter(noopRewriter{}),
+		forward.PassHostHeader(true),
+		forward.Stream(true),
+		forward.ErrorHandler(utils.ErrorHandlerFunc(func(w http.ResponseWriter, req *http.Request, err error) {
+			log.WithField("route_name", h.route.Name).
+				Errorf("orange-cloudfoundry/gobis/forward-proxy: error when calling %s: %s", req.URL.Host, err.Error())
+			serveError(h.errorHandler, w, req, h.route, upstreamErrorStatus(err), ErrorKindUpstream, err)
+		})),
+	)
+	if err != nil {
+		return nil, err
+	}
+	h.handler, err = h.factory.applyMiddlewares(h.route, http.HandlerFunc(h.forward))
+	if err != nil {
+		//nolint:errcheck
+		h.factory.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Close Close handlers created by middlewares, middlewares and shared resources implementing Closer
+func (h *ForwardProxyHandler) Close() error {
+	return h.factory.Close()
+}
+
+func (h *ForwardProxyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect && !req.URL.IsAbs() {
+		serveError(h.errorHandler, w, req, h.route, http.StatusBadRequest, ErrorKindNoRoute,
+			fmt.Errorf("only absolute uri requests and CONNECT are accepted by a forward proxy"))
+		return
+	}
+	IdentityHeaders{}.stripInbound(req)
+	setRouteName(req, h.route.Name)
+	w = newDirtyResponseWriter(w, req)
+	defer panicRecover(h.route, h.errorHandler, w, req)
+	h.handler.ServeHTTP(w, req)
+}
+
+func (h *ForwardProxyHandler) forward(w http.ResponseWriter, req *http.Request) {
+	entry := log.WithField("route_name", h.route.Name)
+	removeDirtyHeaders(req)
+	if !h.isGroupAllowed(req) {
+		entry.Warnf("orange-cloudfoundry/gobis/forward-proxy: user '%s' is not allowed to use proxy", Username(req))
+		serveError(h.errorHandler, w, req, h.route, http.StatusForbidden, ErrorKindDenied, fmt.Errorf("you are not allowed to use this proxy"))
+		return
+	}
+	if err := h.checkDestination(req); err != nil {
+		entry.Warnf("orange-cloudfoundry/gobis/forward-proxy: destination refused for user '%s': %s", Username(req), err.Error())
+		serveError(h.errorHandler, w, req, h.route, http.StatusForbidden, ErrorKindDenied, err)
+		return
+	}
+	if req.Method == http.MethodConnect {
+		h.tunnel(w, req)
+		return
+	}
+	req.RequestURI = req.URL.String()
+	h.fwd.ServeHTTP(w, req)
+}
+
+func (h *ForwardProxyHandler) tunnel(w http.ResponseWriter, req *http.Request) {
+	entry := log.WithField("route_name", h.route.Name)
+	upstream, err := net.DialTimeout("tcp", req.URL.Host, defaultTcpDialTimeout)
+	if err != nil {
+		entry.Errorf("orange-cloudfoundry/gobis/forward-proxy: error when connecting to %s: %s", req.URL.Host, err.Error())
+		serveError(h.errorHandler, w, req, h.route, http.StatusBadGateway, ErrorKindUpstream, fmt.Errorf("cannot connect to %s: %s", req.URL.Host, err.Error()))
+		return
+	}
+	//nolint:errcheck
+	defer upstream.Close()
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		serveError(h.errorHandler, w, req, h.route, http.StatusInternalServerError, ErrorKindInternal, fmt.Errorf("connection can't be hijacked"))
+		return
+	}
+	client, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		entry.Errorf("orange-cloudfoundry/gobis/forward-proxy: error when hijacking connection: %s", err.Error())
+		return
+	}
+	//nolint:errcheck
+	defer client.Close()
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		entry.Errorf("orange-cloudfoundry/gobis/forward-proxy: write failed: %s", err.Error())
+		return
+	}
+	var bytesReceived, bytesSent atomic.Int64
+	timeout := h.config.IdleTimeout.Duration()
+	pipeConns(
+		// data sent by client right after CONNECT may already be buffered by http server
+		&idleConn{Conn: client, reader: clientBuf.Reader, timeout: timeout},
+		&idleConn{Conn: upstream, reader: upstream, timeout: timeout},
+		&bytesReceived,
+		&bytesSent,
+	)
+	entry.Debugf("orange-cloudfoundry/gobis/forward-proxy: tunnel to %s closed (received: %d bytes, sent: %d bytes)",
+		req.URL.Host, bytesReceived.Load(), bytesSent.Load())
+}
+
+func (h *ForwardProxyHandler) isGroupAllowed(req *http.Request) bool {
+	if len(h.config.AllowedGroups) == 0 {
+		return true
+	}
+	for _, group := range Groups(req) {
+		if funk.ContainsString(h.config.AllowedGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *ForwardProxyHandler) checkDestination(req *http.Request) error {
+	host := req.URL.Hostname()
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if host == "" {
+		return fmt.Errorf("no destination host given")
+	}
+	if !h.config.AllowedHosts.Match(host) {
+		return fmt.Errorf("destination host %s is not allowed", host)
+	}
+	if len(h.config.AllowedPorts) == 0 {
+		return nil
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || !funk.ContainsInt(h.config.AllowedPorts, portNumber) {
+		return fmt.Errorf("destination port %s is not allowed", port)
+	}
+	return nil
+}
+
+// noopRewriter Do not add any X-Forwarded-* headers, an egress proxy should not leak internal information
+type noopRewriter struct{}
+
+func (noopRewriter) Rewrite(_ *http.Request) {}
@@ -0,0 +1,119 @@
+package gobis_test
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+type groupsMiddleware struct{}
+
+func (groupsMiddleware) Handler(_ ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if group := req.Header.Get("X-Test-Group"); group != "" {
+			AddGroups(req, group)
+		}
+		next.ServeHTTP(w, req)
+	}), nil
+}
+func (groupsMiddleware) Schema() interface{} {
+	return struct{}{}
+}
+
+var _ = Describe("ForwardProxyHandler", func() {
+	var backend *httptest.Server
+	var backendTls *httptest.Server
+	var proxyServer *httptest.Server
+	var client *http.Client
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			//nolint:errcheck
+			w.Write([]byte("backend"))
+		}))
+		backendTls = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			//nolint:errcheck
+			w.Write([]byte("backend tls"))
+		}))
+		handler, err := NewForwardProxyHandler(ForwardProxyConfig{
+			AllowedHosts:  HostMatchers{NewHostMatcher("127.0.0.*")},
+			AllowedGroups: []string{"egress"},
+		}, &groupsMiddleware{})
+		Expect(err).NotTo(HaveOccurred())
+		proxyServer = httptest.NewServer(handler)
+		proxyUrl, _ := url.Parse(proxyServer.URL)
+		transport := backendTls.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyUrl)
+		transport.ProxyConnectHeader = http.Header{"X-Test-Group": []string{"egress"}}
+		client = &http.Client{Transport: transport}
+	})
+	AfterEach(func() {
+		backend.Close()
+		backendTls.Close()
+		proxyServer.Close()
+	})
+	It("should forward absolute uri requests when destination and groups are allowed", func() {
+		req, _ := http.NewRequest("GET", backend.URL, nil)
+		req.Header.Set("X-Test-Group", "egress")
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		content, _ := io.ReadAll(resp.Body)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(content)).To(Equal("backend"))
+	})
+	It("should create tunnel with CONNECT when destination and groups are allowed", func() {
+		resp, err := client.Get(backendTls.URL)
+		Expect(err).NotTo(HaveOccurred())
+		content, _ := io.ReadAll(resp.Body)
+		Expect(string(content)).To(Equal("backend tls"))
+	})
+	It("should refuse request when user doesn't have an allowed group", func() {
+		resp, err := client.Get(backend.URL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+	})
+	It("should refuse request when destination is not allowed", func() {
+		req, _ := http.NewRequest("GET", "http://not.allowed.local/", nil)
+		req.Header.Set("X-Test-Group", "egress")
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+	})
+	It("should refuse request which is not a proxy request", func() {
+		resp, err := proxyServer.Client().Get(proxyServer.URL + "/path")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+	It("should render errors with error handler given in config", func() {
+		var kinds []ErrorKind
+		handler, err := NewForwardProxyHandler(ForwardProxyConfig{
+			AllowedHosts: HostMatchers{NewHostMatcher("127.0.0.*")},
+			ErrorHandler: ErrorHandlerFunc(func(w http.ResponseWriter, _ *http.Request, _ ProxyRoute, status int, cause error) {
+				var gobisErr *GobisError
+				Expect(errors.As(cause, &gobisErr)).Should(BeTrue())
+				kinds = append(kinds, gobisErr.Kind)
+				w.WriteHeader(status)
+			}),
+		}, &groupsMiddleware{})
+		Expect(err).NotTo(HaveOccurred())
+		errorProxyServer := httptest.NewServer(handler)
+		defer errorProxyServer.Close()
+		proxyUrl, _ := url.Parse(errorProxyServer.URL)
+		errorClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+
+		resp, err := errorClient.Get("http://not.allowed.local/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+		closedBackendUrl := backend.URL
+		backend.Close()
+		resp, err = errorClient.Get(closedBackendUrl)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(kinds).Should(Equal([]ErrorKind{ErrorKindDenied, ErrorKindUpstream}))
+	})
+})
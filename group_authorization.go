@@ -0,0 +1,124 @@
+package gobis
+
+import (
+	"fmt"
+	"github.com/gobwas/glob"
+	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
+	"net/http"
+	"sort"
+)
+
+// RoleMapping Expand groups into roles, key is a group (glob patterns are allowed, e.g.: team-*) and value a list of roles
+// Roles are expanded after middlewares have been called, they are matched with groups by allowed_groups, denied_groups
+// and InGroup in policies but user's groups are left unchanged, use Roles to retrieve them
+type RoleMapping map[string][]string
+
+type groupMatchers []glob.Glob
+
+func compileGroupMatchers(patterns []string) (groupMatchers, error) {
+	matchers := make(groupMatchers, len(patterns))
+	for i, pattern := range patterns {
+		var err error
+		matchers[i], err = glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group pattern '%s': %s", pattern, err.Error())
+		}
+	}
+	return matchers, nil
+}
+
+func (m groupMatchers) matchAny(groups []string) bool {
+	for _, matcher := range m {
+		for _, group := range groups {
+			if matcher.Match(group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type roleMatcher struct {
+	group glob.Glob
+	roles []string
+}
+
+type roleMatchers []roleMatcher
+
+func (r RoleMapping) compile() (roleMatchers, error) {
+	// sort patterns to always expand roles in same order
+	patterns := make([]string, 0, len(r))
+	for pattern := range r {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	matchers := make(roleMatchers, len(patterns))
+	for i, pattern := range patterns {
+		group, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid role mapping group '%s': %s", pattern, err.Error())
+		}
+		matchers[i] = roleMatcher{group: group, roles: r[pattern]}
+	}
+	return matchers, nil
+}
+
+func (m roleMatchers) roles(groups []string) []string {
+	roles := make([]string, 0)
+	for _, matcher := range m {
+		if !(groupMatchers{matcher.group}).matchAny(groups) {
+			continue
+		}
+		for _, role := range matcher.roles {
+			if !funk.ContainsString(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// authorizeHandler Expand roles and check groups of user against allowed and denied groups of route
+func authorizeHandler(proxyRoute ProxyRoute, roleMapping RoleMapping, errorHandler ErrorHandler, next http.Handler) (http.Handler, error) {
+	if len(roleMapping) == 0 && len(proxyRoute.AllowedGroups) == 0 && len(proxyRoute.DeniedGroups) == 0 {
+		return next, nil
+	}
+	roles, err := roleMapping.compile()
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := compileGroupMatchers(proxyRoute.AllowedGroups)
+	if err != nil {
+		return nil, fmt.Errorf("route '%s': allowed_groups: %s", proxyRoute.Name, err.Error())
+	}
+	denied, err := compileGroupMatchers(proxyRoute.DeniedGroups)
+	if err != nil {
+		return nil, fmt.Errorf("route '%s': denied_groups: %s", proxyRoute.Name, err.Error())
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		setRoles(req, roles.roles(Groups(req)))
+		groups := groupsAndRoles(req)
+		entry := log.WithField("route_name", proxyRoute.Name)
+		if denied.matchAny(groups) {
+			entry.Warnf("orange-cloudfoundry/gobis/authorization: user '%s' is in a denied group", Username(req))
+			serveError(errorHandler, w, req, proxyRoute, http.StatusForbidden, ErrorKindDenied, fmt.Errorf("you are not allowed to access this route"))
+			return
+		}
+		if len(allowed) > 0 && !allowed.matchAny(groups) {
+			if Username(req) == "" && len(Groups(req)) == 0 {
+				serveError(errorHandler, w, req, proxyRoute, http.StatusUnauthorized, ErrorKindDenied, fmt.Errorf("authentication is required to access this route"))
+				return
+			}
+			entry.Warnf("orange-cloudfoundry/gobis/authorization: user '%s' is not in an allowed group", Username(req))
+			serveError(errorHandler, w, req, proxyRoute, http.StatusForbidden, ErrorKindDenied, fmt.Errorf("you are not allowed to access this route"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	}), nil
+}
+
+// groupsAndRoles User's groups followed by roles expanded from them
+func groupsAndRoles(req *http.Request) []string {
+	return append(Groups(req), Roles(req)...)
+}
@@ -0,0 +1,114 @@
+package gobis_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+)
+
+var _ = Describe("GroupAuthorization", func() {
+	var handler http.Handler
+	var groups []string
+	var roles []string
+	var headers http.Header
+	BeforeEach(func() {
+		var err error
+		forward := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			groups = Groups(r)
+			sort.Strings(groups)
+			roles = Roles(r)
+			headers = r.Header.Clone()
+		})
+		handler, err = NewDefaultHandler(DefaultHandlerConfig{
+			RoleMapping: RoleMapping{
+				"team-*": {"developer"},
+			},
+			Routes: []ProxyRoute{
+				{
+					Name:           "dev",
+					Path:           NewPathMatcher("/dev/**"),
+					AllowedGroups:  []string{"developer", "admin"},
+					DeniedGroups:   []string{"*-banned"},
+					ForwardHandler: forward,
+				},
+			},
+		}, &groupsMiddleware{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	serve := func(group string) *httptest.ResponseRecorder {
+		groups = nil
+		req := httptest.NewRequest("GET", "http://localhost/dev/path", nil)
+		if group != "" {
+			req.Header.Set("X-Test-Group", group)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	It("should allow users having a role mapped from their groups", func() {
+		recorder := serve("team-a")
+		Expect(recorder.Code).Should(Equal(http.StatusOK))
+		Expect(groups).Should(Equal([]string{"team-a"}))
+		Expect(roles).Should(Equal([]string{"developer"}))
+		Expect(headers.Get(XGobisGroups)).Should(Equal("team-a"))
+		Expect(headers.Get(XGobisRoles)).Should(Equal("developer"))
+	})
+	It("should refuse anonymous users with 401", func() {
+		recorder := serve("")
+		Expect(recorder.Code).Should(Equal(http.StatusUnauthorized))
+		var jsonError JsonError
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &jsonError)).To(Succeed())
+		Expect(jsonError.RouteName).Should(Equal("dev"))
+		Expect(groups).Should(BeNil())
+	})
+	It("should refuse users not in allowed groups or in denied groups with 403", func() {
+		Expect(serve("other").Code).Should(Equal(http.StatusForbidden))
+		Expect(serve("team-banned").Code).Should(Equal(http.StatusForbidden))
+		Expect(groups).Should(BeNil())
+	})
+	It("should check groups of parent route on sub-routes", func() {
+		var err error
+		handler, err = NewDefaultHandler(DefaultHandlerConfig{
+			RoleMapping: RoleMapping{
+				"team-*": {"developer"},
+			},
+			Routes: []ProxyRoute{
+				{
+					Name:          "dev",
+					Path:          NewPathMatcher("/dev/**"),
+					Url:           "http://my.upstream.com",
+					AllowedGroups: []string{"developer"},
+					Routes: []ProxyRoute{
+						{
+							Name:         "sub",
+							Path:         NewPathMatcher("/path"),
+							DeniedGroups: []string{"*-banned"},
+							ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+								groups = Groups(r)
+							}),
+						},
+					},
+				},
+			},
+		}, &groupsMiddleware{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(serve("other").Code).Should(Equal(http.StatusForbidden))
+		Expect(serve("team-banned").Code).Should(Equal(http.StatusForbidden))
+		Expect(groups).Should(BeNil())
+		Expect(serve("team-a").Code).Should(Equal(http.StatusOK))
+		Expect(groups).Should(Equal([]string{"team-a"}))
+	})
+	It("should refuse invalid group patterns", func() {
+		route := ProxyRoute{
+			Name:          "dev",
+			Path:          NewPathMatcher("/dev/**"),
+			Url:           "http://my.upstream.com",
+			AllowedGroups: []string{"[invalid"},
+		}
+		Expect(route.Check()).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,148 @@
+package gobis
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+type HeaderAction string
+
+const (
+	// HeaderActionAdd Add a new value to header, header can have multiple values
+	HeaderActionAdd HeaderAction = "add"
+	// HeaderActionSet Replace all values of header by value
+	HeaderActionSet HeaderAction = "set"
+	// HeaderActionAppend Append value to existing header value separated by a comma, header is created if not exists
+	HeaderActionAppend HeaderAction = "append"
+	// HeaderActionRemove Remove header, value is not used
+	HeaderActionRemove HeaderAction = "remove"
+)
+
+// HeaderRule A rule to rewrite a header
+type HeaderRule struct {
+	// Action to perform on header, one of add, set, append or remove
+	Action HeaderAction `json:"action" yaml:"action"`
+	// Name of the header
+	Name string `json:"name" yaml:"name"`
+	// Value A go template (https://pkg.go.dev/text/template), see HeaderTemplateData for available data
+	// e.g.: `{{ .Username }}` or `{{ join .Groups "," }}` or `{{ .Headers.Get "X-Request-Id" }}`
+	Value string `json:"value" yaml:"value"`
+}
+
+// HeaderRules List of header rules applied in order
+type HeaderRules []HeaderRule
+
+// HeaderTemplateData Data available in header rule value templates
+type HeaderTemplateData struct {
+	// RouteName Name of the route
+	RouteName string
+	// Path Path captured by route which is sent to upstream
+	Path string
+	// ClientIP Ip address of the client
+	ClientIP string
+	// Username User name set by middlewares
+	Username string
+	// Groups User's groups set by middlewares
+	Groups []string
+	// Headers Current headers, request headers for request rules and response headers for response rules
+	Headers http.Header
+}
+
+var headerTemplateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+func (r HeaderRule) Check() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("header name can't be empty")
+	}
+	switch r.Action {
+	case HeaderActionAdd, HeaderActionSet, HeaderActionAppend, HeaderActionRemove:
+	default:
+		return fmt.Errorf("unknown action '%s' for header %s, must be one of add, set, append or remove", r.Action, r.Name)
+	}
+	_, err := r.template()
+	return err
+}
+
+func (r HeaderRule) template() (*template.Template, error) {
+	tmpl, err := template.New(r.Name).Funcs(headerTemplateFuncs).Parse(r.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value template for header %s: %s", r.Name, err.Error())
+	}
+	return tmpl, nil
+}
+
+func (rules HeaderRules) Check() error {
+	for i, rule := range rules {
+		if err := rule.Check(); err != nil {
+			return fmt.Errorf("rule %d: %s", i, err.Error())
+		}
+	}
+	return nil
+}
+
+// compiledHeaderRule A header rule with its value template already parsed
+type compiledHeaderRule struct {
+	HeaderRule
+	tmpl *template.Template
+}
+
+type compiledHeaderRules []compiledHeaderRule
+
+func (rules HeaderRules) compile() (compiledHeaderRules, error) {
+	if err := rules.Check(); err != nil {
+		return nil, err
+	}
+	compiled := make(compiledHeaderRules, len(rules))
+	for i, rule := range rules {
+		tmpl, _ := rule.template()
+		compiled[i] = compiledHeaderRule{HeaderRule: rule, tmpl: tmpl}
+	}
+	return compiled, nil
+}
+
+// apply Rewrite headers, data.Headers must be headers to rewrite
+func (rules compiledHeaderRules) apply(data HeaderTemplateData) error {
+	headers := data.Headers
+	for _, rule := range rules {
+		if rule.Action == HeaderActionRemove {
+			headers.Del(rule.Name)
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := rule.tmpl.Execute(buf, data); err != nil {
+			return fmt.Errorf("error when rendering value of header %s: %s", rule.Name, err.Error())
+		}
+		value := buf.String()
+		switch rule.Action {
+		case HeaderActionAdd:
+			headers.Add(rule.Name, value)
+		case HeaderActionSet:
+			headers.Set(rule.Name, value)
+		case HeaderActionAppend:
+			current := strings.Join(headers.Values(rule.Name), ", ")
+			if current != "" {
+				value = current + ", " + value
+			}
+			headers.Set(rule.Name, value)
+		}
+	}
+	return nil
+}
+
+func newHeaderTemplateData(routeName string, req *http.Request, headers http.Header) HeaderTemplateData {
+	return HeaderTemplateData{
+		RouteName: routeName,
+		Path:      Path(req),
+		ClientIP:  ClientIP(req),
+		Username:  Username(req),
+		Groups:    Groups(req),
+		Headers:   headers,
+	}
+}
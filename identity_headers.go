@@ -0,0 +1,102 @@
+package gobis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// gobisHeaderPrefix All inbound headers starting with this prefix are removed as only gobis can set them
+const gobisHeaderPrefix = "X-Gobis-"
+
+// IdentityHeaders Headers used to send user identity set by middlewares to upstream
+type IdentityHeaders struct {
+	// UsernameHeader Header name used to send username (Default: X-Gobis-Username)
+	UsernameHeader string `json:"username_header" yaml:"username_header"`
+	// GroupsHeader Header name used to send user's groups separated by a comma (Default: X-Gobis-Groups)
+	GroupsHeader string `json:"groups_header" yaml:"groups_header"`
+	// RolesHeader Header name used to send roles expanded with role mapping separated by a comma (Default: X-Gobis-Roles)
+	// It is only sent when user has roles
+	RolesHeader string `json:"roles_header" yaml:"roles_header"`
+	// ClaimHeaders Send selected principal claims to upstream, key is claim name and value header name
+	// e.g.: {"email": "X-Gobis-Email", "tenant": "X-Gobis-Tenant"}, values which are not strings are json encoded
+	ClaimHeaders map[string]string `json:"claim_headers" yaml:"claim_headers"`
+	// SkipAnonymous Set to true to not send identity headers when no user has been set by middlewares
+	SkipAnonymous bool `json:"skip_anonymous" yaml:"skip_anonymous"`
+}
+
+func (h IdentityHeaders) usernameHeader() string {
+	if h.UsernameHeader == "" {
+		return XGobisUsername
+	}
+	return h.UsernameHeader
+}
+
+func (h IdentityHeaders) groupsHeader() string {
+	if h.GroupsHeader == "" {
+		return XGobisGroups
+	}
+	return h.GroupsHeader
+}
+
+func (h IdentityHeaders) rolesHeader() string {
+	if h.RolesHeader == "" {
+		return XGobisRoles
+	}
+	return h.RolesHeader
+}
+
+// stripInbound Remove headers sent by client which could be used to spoof identity
+func (h IdentityHeaders) stripInbound(req *http.Request) {
+	for header := range req.Header {
+		if strings.HasPrefix(header, gobisHeaderPrefix) {
+			req.Header.Del(header)
+		}
+	}
+	h.remove(req)
+}
+
+// set Set identity headers from request context
+func (h IdentityHeaders) set(req *http.Request) {
+	username := Username(req)
+	groups := Groups(req)
+	if h.SkipAnonymous && username == "" && len(groups) == 0 {
+		h.remove(req)
+		return
+	}
+	req.Header.Set(h.usernameHeader(), username)
+	req.Header.Set(h.groupsHeader(), strings.Join(groups, ","))
+	req.Header.Del(h.rolesHeader())
+	if roles := Roles(req); len(roles) > 0 {
+		req.Header.Set(h.rolesHeader(), strings.Join(roles, ","))
+	}
+	principal := RequestPrincipal(req)
+	for claim, header := range h.ClaimHeaders {
+		value, ok := principal.Claim(claim)
+		if !ok {
+			continue
+		}
+		req.Header.Set(header, claimToHeaderValue(value))
+	}
+}
+
+func (h IdentityHeaders) remove(req *http.Request) {
+	req.Header.Del(h.usernameHeader())
+	req.Header.Del(h.groupsHeader())
+	req.Header.Del(h.rolesHeader())
+	for _, header := range h.ClaimHeaders {
+		req.Header.Del(header)
+	}
+}
+
+func claimToHeaderValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
@@ -0,0 +1,137 @@
+package gobis
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// IdentitySource Headers set by a trusted component in front of gobis (sso proxy, mtls terminator, ...)
+// which are used to set username and groups in request context
+type IdentitySource struct {
+	// TrustedCIDRs List of ip addresses or cidrs (e.g.: 10.0.0.0/8) allowed to send these headers
+	// Headers sent from other addresses are removed
+	TrustedCIDRs []string `json:"trusted_cidrs" yaml:"trusted_cidrs"`
+	// UsernameHeader Header containing username, e.g.: X-Remote-User
+	UsernameHeader string `json:"username_header" yaml:"username_header"`
+	// UsernameRegex Regex to extract username from header value, first capture group is used if any
+	// e.g.: `CN=([^,]+)` to extract common name from a subject
+	UsernameRegex string `json:"username_regex" yaml:"username_regex"`
+	// GroupsHeader Header containing user's groups, e.g.: X-Remote-Groups
+	GroupsHeader string `json:"groups_header" yaml:"groups_header"`
+	// GroupsSeparator Separator between groups in header value (Default: ,)
+	GroupsSeparator string `json:"groups_separator" yaml:"groups_separator"`
+	// GroupsRegex Regex to extract each group, first capture group is used if any, groups not matching are ignored
+	GroupsRegex string `json:"groups_regex" yaml:"groups_regex"`
+}
+
+type identitySource struct {
+	IdentitySource
+	trusted       trustedProxies
+	usernameRegex *regexp.Regexp
+	groupsRegex   *regexp.Regexp
+}
+
+type identitySources []identitySource
+
+func newIdentitySources(sources []IdentitySource) (identitySources, error) {
+	compiled := make(identitySources, len(sources))
+	for i, source := range sources {
+		var err error
+		compiled[i], err = newIdentitySource(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity source %d: %s", i, err.Error())
+		}
+	}
+	return compiled, nil
+}
+
+func newIdentitySource(source IdentitySource) (identitySource, error) {
+	compiled := identitySource{IdentitySource: source}
+	if len(source.TrustedCIDRs) == 0 {
+		return compiled, fmt.Errorf("trusted_cidrs can't be empty")
+	}
+	if source.UsernameHeader == "" && source.GroupsHeader == "" {
+		return compiled, fmt.Errorf("username_header or groups_header must be set")
+	}
+	if compiled.GroupsSeparator == "" {
+		compiled.GroupsSeparator = ","
+	}
+	var err error
+	compiled.trusted, err = parseTrustedProxies(source.TrustedCIDRs)
+	if err != nil {
+		return compiled, err
+	}
+	if source.UsernameRegex != "" {
+		compiled.usernameRegex, err = regexp.Compile(source.UsernameRegex)
+		if err != nil {
+			return compiled, fmt.Errorf("invalid username_regex: %s", err.Error())
+		}
+	}
+	if source.GroupsRegex != "" {
+		compiled.groupsRegex, err = regexp.Compile(source.GroupsRegex)
+		if err != nil {
+			return compiled, fmt.Errorf("invalid groups_regex: %s", err.Error())
+		}
+	}
+	return compiled, nil
+}
+
+// apply Set identity from sources trusting request peer and remove headers from others
+func (sources identitySources) apply(req *http.Request) {
+	peerIp := remoteIP(req)
+	for _, source := range sources {
+		if !source.trusted.isTrusted(peerIp) {
+			source.removeHeaders(req)
+			continue
+		}
+		source.setIdentity(req)
+	}
+}
+
+func (s identitySource) removeHeaders(req *http.Request) {
+	if s.UsernameHeader != "" {
+		req.Header.Del(s.UsernameHeader)
+	}
+	if s.GroupsHeader != "" {
+		req.Header.Del(s.GroupsHeader)
+	}
+}
+
+func (s identitySource) setIdentity(req *http.Request) {
+	if s.UsernameHeader != "" && Username(req) == "" {
+		username := extractWithRegex(s.usernameRegex, strings.TrimSpace(req.Header.Get(s.UsernameHeader)))
+		if username != "" {
+			SetUsername(req, username)
+		}
+	}
+	if s.GroupsHeader == "" {
+		return
+	}
+	groups := make([]string, 0)
+	for _, value := range req.Header.Values(s.GroupsHeader) {
+		for _, group := range strings.Split(value, s.GroupsSeparator) {
+			group = extractWithRegex(s.groupsRegex, strings.TrimSpace(group))
+			if group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	AddGroups(req, groups...)
+}
+
+// extractWithRegex Return first capture group (or full match if no group) of regex, empty string is returned if not match
+func extractWithRegex(regex *regexp.Regexp, value string) string {
+	if regex == nil || value == "" {
+		return value
+	}
+	sub := regex.FindStringSubmatch(value)
+	if len(sub) == 0 {
+		return ""
+	}
+	if len(sub) >= 2 {
+		return sub[1]
+	}
+	return sub[0]
+}
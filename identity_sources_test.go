@@ -0,0 +1,93 @@
+package gobis_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+)
+
+var _ = Describe("IdentitySources", func() {
+	var username string
+	var groups []string
+	var receivedHeaders http.Header
+	var handler http.Handler
+	BeforeEach(func() {
+		var err error
+		handler, err = NewDefaultHandler(DefaultHandlerConfig{
+			IdentitySources: []IdentitySource{
+				{
+					TrustedCIDRs:   []string{"10.0.0.0/8"},
+					UsernameHeader: "X-Remote-User",
+					GroupsHeader:   "X-Remote-Groups",
+					GroupsRegex:    `^cn=([^,]+)`,
+				},
+				{
+					TrustedCIDRs:    []string{"192.168.0.1"},
+					UsernameHeader:  "X-Client-Subject",
+					UsernameRegex:   `CN=([^,]+)`,
+					GroupsHeader:    "X-Client-Groups",
+					GroupsSeparator: ";",
+				},
+			},
+			Routes: []ProxyRoute{
+				{
+					Name: "app",
+					Path: NewPathMatcher("/app/**"),
+					ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						username = Username(r)
+						groups = Groups(r)
+						sort.Strings(groups)
+						receivedHeaders = r.Header.Clone()
+					}),
+				},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+	serve := func(remoteAddr string, headers map[string][]string) {
+		req := httptest.NewRequest("GET", "http://localhost/app/path", nil)
+		req.RemoteAddr = remoteAddr
+		for key, values := range headers {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	It("should set identity from headers sent by a trusted source", func() {
+		serve("10.1.2.3:1234", map[string][]string{
+			"X-Remote-User":   {"alice"},
+			"X-Remote-Groups": {"cn=admin,ou=groups", "invalid"},
+		})
+		Expect(username).Should(Equal("alice"))
+		Expect(groups).Should(Equal([]string{"admin"}))
+		Expect(receivedHeaders.Get(XGobisUsername)).Should(Equal("alice"))
+	})
+	It("should extract identity with regex and separator", func() {
+		serve("192.168.0.1:1234", map[string][]string{
+			"X-Client-Subject": {"CN=bob,O=Org"},
+			"X-Client-Groups":  {"dev; ops"},
+		})
+		Expect(username).Should(Equal("bob"))
+		Expect(groups).Should(Equal([]string{"dev", "ops"}))
+	})
+	It("should ignore and remove headers sent by an untrusted client", func() {
+		serve("172.16.0.1:1234", map[string][]string{
+			"X-Remote-User":    {"admin"},
+			"X-Client-Subject": {"CN=admin"},
+		})
+		Expect(username).Should(BeEmpty())
+		Expect(groups).Should(BeEmpty())
+		Expect(receivedHeaders).ShouldNot(HaveKey("X-Remote-User"))
+		Expect(receivedHeaders).ShouldNot(HaveKey("X-Client-Subject"))
+	})
+	It("should refuse sources without trusted cidrs", func() {
+		_, err := NewDefaultHandler(DefaultHandlerConfig{
+			IdentitySources: []IdentitySource{{UsernameHeader: "X-Remote-User"}},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
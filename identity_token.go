@@ -0,0 +1,287 @@
+package gobis
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/google/uuid"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	XGobisIdentity           = "X-Gobis-Identity"
+	defaultIdentityTokenTTL  = time.Minute
+	defaultIdentityJwksPath  = "/.well-known/gobis/jwks.json"
+	defaultRequestIdHeader   = "X-Request-Id"
+	identityTokenAlgorithmRS = "RS256"
+	identityTokenAlgorithmES = "ES256"
+)
+
+// IdentityTokenConfig Configuration to send user identity to upstream as a signed JWT
+// Token is signed with RS256 (rsa key) or ES256 (ecdsa P-256 key) and contains claims:
+// sub (username), groups, roles (when user has roles), route, jti (request id), iss, aud, iat and exp
+type IdentityTokenConfig struct {
+	// KeyFile Path to a PEM encoded private key (PKCS#1, PKCS#8 or SEC 1) used to sign tokens
+	KeyFile string `json:"key_file" yaml:"key_file"`
+	// Key Private key used to sign tokens when using gobis programmatically, it must be a *rsa.PrivateKey or an *ecdsa.PrivateKey
+	Key crypto.Signer `json:"-" yaml:"-"`
+	// KeyID Id of the key set in token header and in jwks (Default: sha256 thumbprint of public key)
+	KeyID string `json:"key_id" yaml:"key_id"`
+	// Header Header name used to send token to upstream (Default: X-Gobis-Identity)
+	Header string `json:"header" yaml:"header"`
+	// Issuer Value of iss claim (Default: gobis)
+	Issuer string `json:"issuer" yaml:"issuer"`
+	// Audience Value of aud claim (Default: no audience)
+	Audience string `json:"audience" yaml:"audience"`
+	// TTL Lifetime of tokens (Default: 1m)
+	TTL Duration `json:"ttl" yaml:"ttl"`
+	// RequestIdHeader Header where request id is read to be set as jti claim, an uuid is generated if not found (Default: X-Request-Id)
+	RequestIdHeader string `json:"request_id_header" yaml:"request_id_header"`
+	// JwksPath Path where gobis serves public key as a JSON Web Key Set (Default: /.well-known/gobis/jwks.json)
+	JwksPath string `json:"jwks_path" yaml:"jwks_path"`
+	// Claims List of principal claims to add to token (see Principal.Claim), e.g.: ["email", "tenant"]
+	Claims []string `json:"claims" yaml:"claims"`
+	// OnlyToken Set to true to not send plain username and groups headers anymore
+	OnlyToken bool `json:"only_token" yaml:"only_token"`
+}
+
+// IdentityTokenSigner Mint identity tokens for requests sent to upstream and serve jwks to validate them
+type IdentityTokenSigner struct {
+	config    IdentityTokenConfig
+	key       crypto.Signer
+	algorithm string
+	jwks      []byte
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func NewIdentityTokenSigner(config IdentityTokenConfig) (*IdentityTokenSigner, error) {
+	key := config.Key
+	if key == nil {
+		if config.KeyFile == "" {
+			return nil, fmt.Errorf("identity token: key_file must be set")
+		}
+		var err error
+		key, err = loadSigningKey(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("identity token: %s", err.Error())
+		}
+	}
+	if config.Header == "" {
+		config.Header = XGobisIdentity
+	}
+	if config.Issuer == "" {
+		config.Issuer = "gobis"
+	}
+	if config.TTL <= 0 {
+		config.TTL = Duration(defaultIdentityTokenTTL)
+	}
+	if config.RequestIdHeader == "" {
+		config.RequestIdHeader = defaultRequestIdHeader
+	}
+	if config.JwksPath == "" {
+		config.JwksPath = defaultIdentityJwksPath
+	}
+	signer := &IdentityTokenSigner{
+		config: config,
+		key:    key,
+	}
+	jwk, err := signer.jsonWebKey()
+	if err != nil {
+		return nil, fmt.Errorf("identity token: %s", err.Error())
+	}
+	signer.config.KeyID = jwk.Kid
+	signer.algorithm = jwk.Alg
+	signer.jwks, err = json.Marshal(map[string][]jsonWebKey{"keys": {jwk}})
+	if err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+func loadSigningKey(keyFile string) (crypto.Signer, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file: %s", err.Error())
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("key file %s is not PEM encoded", keyFile)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key from %s: %s", keyFile, err.Error())
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type in %s", keyFile)
+	}
+	return signer, nil
+}
+
+func (s *IdentityTokenSigner) jsonWebKey() (jsonWebKey, error) {
+	b64 := base64.RawURLEncoding
+	var jwk jsonWebKey
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		jwk = jsonWebKey{
+			Kty: "RSA",
+			Alg: identityTokenAlgorithmRS,
+			N:   b64.EncodeToString(key.N.Bytes()),
+			E:   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return jwk, fmt.Errorf("only ecdsa keys with curve P-256 are supported")
+		}
+		x, y := ecdsaPublicCoordinates(key)
+		jwk = jsonWebKey{
+			Kty: "EC",
+			Alg: identityTokenAlgorithmES,
+			Crv: "P-256",
+			X:   b64.EncodeToString(x),
+			Y:   b64.EncodeToString(y),
+		}
+	default:
+		return jwk, fmt.Errorf("unsupported key type %T, only rsa and ecdsa keys are supported", s.key)
+	}
+	jwk.Use = "sig"
+	jwk.Kid = s.config.KeyID
+	if jwk.Kid == "" {
+		der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+		if err != nil {
+			return jwk, err
+		}
+		sum := sha256.Sum256(der)
+		jwk.Kid = b64.EncodeToString(sum[:])
+	}
+	return jwk, nil
+}
+
+// ecdsaPublicCoordinates Retrieve x and y of a P-256 public key padded to 32 bytes
+func ecdsaPublicCoordinates(key *ecdsa.PrivateKey) ([]byte, []byte) {
+	// uncompressed point: 0x04 || x || y
+	point, _ := key.PublicKey.Bytes()
+	return point[1:33], point[33:65]
+}
+
+// Header Name of the header where token is sent
+func (s *IdentityTokenSigner) Header() string {
+	return s.config.Header
+}
+
+// JwksPath Path where jwks is served
+func (s *IdentityTokenSigner) JwksPath() string {
+	return s.config.JwksPath
+}
+
+// Sign Create a signed token for the user found in request context
+func (s *IdentityTokenSigner) Sign(req *http.Request, routeName string) (string, error) {
+	requestId := req.Header.Get(s.config.RequestIdHeader)
+	if requestId == "" {
+		requestId = uuid.NewString()
+	}
+	principal := RequestPrincipal(req)
+	claims := make(map[string]interface{})
+	for _, name := range s.config.Claims {
+		if value, ok := principal.Claim(name); ok {
+			claims[name] = value
+		}
+	}
+	now := time.Now()
+	claims["iss"] = s.config.Issuer
+	if s.config.Audience != "" {
+		claims["aud"] = s.config.Audience
+	}
+	claims["sub"] = principal.Username
+	claims["groups"] = principal.Groups
+	if roles := Roles(req); len(roles) > 0 {
+		claims["roles"] = roles
+	}
+	claims["route"] = routeName
+	claims["jti"] = requestId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.config.TTL.Duration()).Unix()
+	header, err := json.Marshal(map[string]string{
+		"alg": s.algorithm,
+		"typ": "JWT",
+		"kid": s.config.KeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	b64 := base64.RawURLEncoding
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	signature, err := s.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64.EncodeToString(signature), nil
+}
+
+func (s *IdentityTokenSigner) sign(signingInput []byte) ([]byte, error) {
+	digest := sha256.Sum256(signingInput)
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, sig, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		sig.FillBytes(signature[32:])
+		return signature, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", s.key)
+}
+
+// ServeHTTP Serve public key as a JSON Web Key Set
+func (s *IdentityTokenSigner) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	//nolint:errcheck
+	w.Write(s.jwks)
+}
+
+// setIdentityToken Set identity token header on request sent to upstream
+func (s *IdentityTokenSigner) setIdentityToken(req *http.Request, routeName string) error {
+	token, err := s.Sign(req, routeName)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(s.config.Header, token)
+	return nil
+}
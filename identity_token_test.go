@@ -0,0 +1,145 @@
+package gobis_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func decodeTokenPart(part string, v interface{}) {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(json.Unmarshal(b, v)).To(Succeed())
+}
+
+var _ = Describe("IdentityToken", func() {
+	var receivedHeaders http.Header
+	var usernameMiddleware = &groupsMiddleware{}
+	newHandler := func(config IdentityTokenConfig) http.Handler {
+		handler, err := NewDefaultHandler(DefaultHandlerConfig{
+			IdentityToken: &config,
+			Routes: []ProxyRoute{
+				{
+					Name: "app",
+					Path: NewPathMatcher("/app/**"),
+					ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						receivedHeaders = r.Header.Clone()
+					}),
+				},
+			},
+		}, usernameMiddleware)
+		Expect(err).NotTo(HaveOccurred())
+		return handler
+	}
+	serve := func(handler http.Handler) {
+		req := httptest.NewRequest("GET", "http://localhost/app/path", nil)
+		req.Header.Set("X-Test-Group", "group,with,commas")
+		req.Header.Set("X-Request-Id", "request-1")
+		req.Header.Set(XGobisIdentity, "forged")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	It("should send a token signed with rsa key and serve jwks", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		handler := newHandler(IdentityTokenConfig{Key: key, Audience: "upstream"})
+		serve(handler)
+
+		token := receivedHeaders.Get(XGobisIdentity)
+		parts := strings.Split(token, ".")
+		Expect(parts).Should(HaveLen(3))
+		var header map[string]string
+		decodeTokenPart(parts[0], &header)
+		Expect(header["alg"]).Should(Equal("RS256"))
+		var claims map[string]interface{}
+		decodeTokenPart(parts[1], &claims)
+		Expect(claims["groups"]).Should(Equal([]interface{}{"group,with,commas"}))
+		Expect(claims["route"]).Should(Equal("app"))
+		Expect(claims["jti"]).Should(Equal("request-1"))
+		Expect(claims["aud"]).Should(Equal("upstream"))
+		Expect(claims["iss"]).Should(Equal("gobis"))
+		Expect(claims["exp"]).Should(BeNumerically(">", claims["iat"]))
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		Expect(err).NotTo(HaveOccurred())
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		Expect(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature)).To(Succeed())
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost/.well-known/gobis/jwks.json", nil))
+		Expect(recorder.Code).Should(Equal(http.StatusOK))
+		var jwks struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &jwks)).To(Succeed())
+		Expect(jwks.Keys).Should(HaveLen(1))
+		Expect(jwks.Keys[0]["kid"]).Should(Equal(header["kid"]))
+		n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0]["n"])
+		Expect(new(big.Int).SetBytes(n).Cmp(key.N)).Should(Equal(0))
+	})
+	It("should send a token signed with ecdsa key without plain headers when asked", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		handler := newHandler(IdentityTokenConfig{Key: key, KeyID: "mykey", OnlyToken: true, Header: "X-Identity"})
+		serve(handler)
+
+		Expect(receivedHeaders).ShouldNot(HaveKey(XGobisGroups))
+		Expect(receivedHeaders).ShouldNot(HaveKey(XGobisIdentity))
+		parts := strings.Split(receivedHeaders.Get("X-Identity"), ".")
+		Expect(parts).Should(HaveLen(3))
+		var header map[string]string
+		decodeTokenPart(parts[0], &header)
+		Expect(header["alg"]).Should(Equal("ES256"))
+		Expect(header["kid"]).Should(Equal("mykey"))
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signature).Should(HaveLen(64))
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		Expect(ecdsa.Verify(&key.PublicKey, digest[:], r, s)).Should(BeTrue())
+	})
+	It("should strip custom token header sent by client", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		handler := newHandler(IdentityTokenConfig{Key: key, Header: "Authorization-Identity"})
+		req := httptest.NewRequest("GET", "http://localhost/app/path", nil)
+		req.Header.Set("Authorization-Identity", "forged")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(receivedHeaders.Get("Authorization-Identity")).ShouldNot(Equal("forged"))
+		Expect(strings.Split(receivedHeaders.Get("Authorization-Identity"), ".")).Should(HaveLen(3))
+	})
+	It("should not forward request when token can't be signed", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		handler := newHandler(IdentityTokenConfig{Key: key, Header: "Authorization-Identity"})
+		// corrupt key after signer creation to make signing fail
+		key.Primes = nil
+		key.D = big.NewInt(0)
+		key.N = big.NewInt(1)
+
+		receivedHeaders = nil
+		req := httptest.NewRequest("GET", "http://localhost/app/path", nil)
+		req.Header.Set("Authorization-Identity", "forged")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		Expect(recorder.Code).Should(Equal(http.StatusInternalServerError))
+		Expect(receivedHeaders).Should(BeNil())
+	})
+	It("should refuse configuration without key", func() {
+		_, err := NewIdentityTokenSigner(IdentityTokenConfig{})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,275 @@
+package gobis
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+//go:generate go run ./cmd/gobis-schema -o gobis.schema.json
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// JSONSchema A JSON Schema (draft-07) document or sub schema
+type JSONSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Ref         string `json:"$ref,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type A type name or a list of type names
+	Type       interface{}            `json:"type,omitempty"`
+	Format     string                 `json:"format,omitempty"`
+	Enum       []interface{}          `json:"enum,omitempty"`
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Items      *JSONSchema            `json:"items,omitempty"`
+	// AdditionalProperties A boolean or a *JSONSchema
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Definitions          map[string]*JSONSchema `json:"definitions,omitempty"`
+}
+
+// JSONSchemaProvider Types which are not loaded as their go structure (e.g.: Duration) give their own schema
+type JSONSchemaProvider interface {
+	JSONSchema() *JSONSchema
+}
+
+// GenerateJSONSchema Generate a JSON Schema of DefaultHandlerConfig, params of given middlewares are added to routes
+// This can be used by editors to validate and autocomplete config files
+func GenerateJSONSchema(middlewares ...MiddlewareHandler) *JSONSchema {
+	gen := newJSONSchemaGenerator("json", true)
+	gen.schema(reflect.TypeOf(DefaultHandlerConfig{}))
+	root := *gen.definitions["DefaultHandlerConfig"]
+	delete(gen.definitions, "DefaultHandlerConfig")
+	root.Schema = jsonSchemaDraft
+	root.Title = "gobis configuration"
+	root.Definitions = gen.definitions
+
+	route := gen.definitions["ProxyRoute"]
+	route.Required = []string{"name", "path"}
+	flatParams := &JSONSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema),
+		AdditionalProperties: true,
+	}
+	paramsByMiddleware := &JSONSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema),
+		AdditionalProperties: true,
+	}
+	names := make([]interface{}, len(middlewares))
+	for i, middleware := range middlewares {
+		name := MiddlewareName(middleware)
+		names[i] = name
+		params := MiddlewareParamsJSONSchema(middleware)
+		paramsByMiddleware.Properties[name] = params
+		for key, prop := range params.Properties {
+			if _, ok := flatParams.Properties[key]; !ok {
+				flatParams.Properties[key] = prop
+			}
+		}
+	}
+	route.Properties["middleware_params"] = flatParams
+	route.Properties["params_by_middleware"] = paramsByMiddleware
+	if len(names) > 0 {
+		middlewaresNames := &JSONSchema{Type: "array", Items: &JSONSchema{Type: "string", Enum: names}}
+		route.Properties["middlewares"] = middlewaresNames
+		root.Properties["middlewares"] = middlewaresNames
+	}
+	return &root
+}
+
+// MiddlewareParamsJSONSchema Generate a JSON Schema of params of a middleware from its schema and mapstructure tags
+func MiddlewareParamsJSONSchema(middleware MiddlewareHandler) *JSONSchema {
+	gen := newJSONSchemaGenerator("mapstructure", false)
+	schema := gen.schema(reflect.TypeOf(middleware.Schema()))
+	if schema == nil {
+		return &JSONSchema{}
+	}
+	return schema
+}
+
+type jsonSchemaGenerator struct {
+	tagName string
+	// useDefinitions Named structures are set in definitions and referenced
+	useDefinitions bool
+	definitions    map[string]*JSONSchema
+	visiting       map[reflect.Type]bool
+}
+
+func newJSONSchemaGenerator(tagName string, useDefinitions bool) *jsonSchemaGenerator {
+	return &jsonSchemaGenerator{
+		tagName:        tagName,
+		useDefinitions: useDefinitions,
+		definitions:    make(map[string]*JSONSchema),
+		visiting:       make(map[reflect.Type]bool),
+	}
+}
+
+// schema Generate schema of a type, nil is returned for types which can't be set in a config file (e.g.: functions)
+func (g *jsonSchemaGenerator) schema(t reflect.Type) *JSONSchema {
+	if t == nil {
+		return &JSONSchema{}
+	}
+	if provider, ok := reflect.New(t).Interface().(JSONSchemaProvider); ok {
+		return provider.JSONSchema()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string"}
+		}
+		items := g.schema(t.Elem())
+		if items == nil {
+			return nil
+		}
+		return &JSONSchema{Type: "array", Items: items}
+	case reflect.Map:
+		values := g.schema(t.Elem())
+		if values == nil {
+			return nil
+		}
+		return &JSONSchema{Type: "object", AdditionalProperties: values}
+	case reflect.Interface:
+		return &JSONSchema{}
+	case reflect.Struct:
+		return g.structSchema(t)
+	}
+	return nil
+}
+
+func (g *jsonSchemaGenerator) structSchema(t reflect.Type) *JSONSchema {
+	if g.useDefinitions && t.Name() != "" {
+		ref := &JSONSchema{Ref: "#/definitions/" + t.Name()}
+		if _, ok := g.definitions[t.Name()]; ok || g.visiting[t] {
+			return ref
+		}
+		g.visiting[t] = true
+		g.definitions[t.Name()] = g.objectSchema(t)
+		delete(g.visiting, t)
+		return ref
+	}
+	if g.visiting[t] {
+		// recursive structure without definitions
+		return &JSONSchema{Type: "object"}
+	}
+	g.visiting[t] = true
+	defer delete(g.visiting, t)
+	return g.objectSchema(t)
+}
+
+func (g *jsonSchemaGenerator) objectSchema(t reflect.Type) *JSONSchema {
+	object := &JSONSchema{
+		Type:       "object",
+		Properties: make(map[string]*JSONSchema),
+	}
+	g.addFields(object, t)
+	return object
+}
+
+func (g *jsonSchemaGenerator) addFields(object *JSONSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, squash, skip := g.fieldName(field)
+		if skip {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if squash && fieldType.Kind() == reflect.Struct {
+			g.addFields(object, fieldType)
+			continue
+		}
+		schema := g.schema(field.Type)
+		if schema == nil {
+			continue
+		}
+		object.Properties[name] = schema
+	}
+}
+
+// fieldName Retrieve key of a field from its tag, squash is true when fields of an embedded structure are promoted
+// Fields without name in their mapstructure tag are lowercased as mapstructure match keys case insensitively
+func (g *jsonSchemaGenerator) fieldName(field reflect.StructField) (name string, squash bool, skip bool) {
+	tag := field.Tag.Get(g.tagName)
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	for _, opt := range parts[1:] {
+		if opt == "squash" {
+			squash = true
+		}
+	}
+	if g.tagName == "json" && field.Anonymous && name == "" {
+		squash = true
+	}
+	if name == "" {
+		name = field.Name
+		if g.tagName == "mapstructure" {
+			name = strings.ToLower(name)
+		}
+	}
+	return name, squash, false
+}
+
+func (Duration) JSONSchema() *JSONSchema {
+	return &JSONSchema{
+		Type:        []string{"string", "integer"},
+		Description: "a duration, e.g.: 30s, 5m or 1h30m, or a number of seconds",
+	}
+}
+
+func (PathMatcher) JSONSchema() *JSONSchema {
+	return &JSONSchema{
+		Type:        "string",
+		Description: "a path, /* matches first level and /** everything, e.g.: /app/**",
+	}
+}
+
+func (HostMatcher) JSONSchema() *JSONSchema {
+	return &JSONSchema{
+		Type:        "string",
+		Description: "a host, wildcards are allowed, e.g.: *.my.domain.com",
+	}
+}
+
+func (ClientCertMode) JSONSchema() *JSONSchema {
+	return &JSONSchema{
+		Type: "string",
+		Enum: []interface{}{"", ClientCertOptional, ClientCertRequired, ClientCertIgnored},
+	}
+}
+
+func (HeaderAction) JSONSchema() *JSONSchema {
+	return &JSONSchema{
+		Type: "string",
+		Enum: []interface{}{HeaderActionAdd, HeaderActionSet, HeaderActionAppend, HeaderActionRemove},
+	}
+}
+
+func (PolicyEffect) JSONSchema() *JSONSchema {
+	return &JSONSchema{
+		Type: "string",
+		Enum: []interface{}{PolicyAllow, PolicyDeny},
+	}
+}
@@ -0,0 +1,85 @@
+package gobis_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+)
+
+type untaggedParamsMiddleware struct{}
+
+func (untaggedParamsMiddleware) Handler(_ ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	return next, nil
+}
+func (untaggedParamsMiddleware) Schema() interface{} {
+	return struct {
+		MaxSize int
+		Tagged  string `mapstructure:"tagged_name"`
+	}{}
+}
+
+var _ = Describe("JSONSchema", func() {
+	Context("GenerateJSONSchema", func() {
+		var schema *JSONSchema
+		BeforeEach(func() {
+			schema = GenerateJSONSchema(&limitMiddleware{}, &paramsMiddleware{name: "cors"})
+		})
+		It("should generate schema of handler config with routes as definitions", func() {
+			Expect(schema.Schema).Should(Equal("http://json-schema.org/draft-07/schema#"))
+			Expect(schema.Type).Should(Equal("object"))
+			Expect(schema.Properties["routes"].Items.Ref).Should(Equal("#/definitions/ProxyRoute"))
+			Expect(schema.Properties["start_path"].Type).Should(Equal("string"))
+			Expect(schema.Properties["dns_cache_ttl"].Type).Should(Equal([]string{"string", "integer"}))
+
+			route := schema.Definitions["ProxyRoute"]
+			Expect(route).ShouldNot(BeNil())
+			Expect(route.Required).Should(Equal([]string{"name", "path"}))
+			Expect(route.Properties["routes"].Items.Ref).Should(Equal("#/definitions/ProxyRoute"))
+			Expect(route.Properties["path"].Type).Should(Equal("string"))
+			Expect(route.Properties["client_cert"].Enum).Should(ContainElement(ClientCertRequired))
+			Expect(route.Properties).ShouldNot(HaveKey("ForwardHandler"))
+		})
+		It("should add params of middlewares to routes", func() {
+			route := schema.Definitions["ProxyRoute"]
+			Expect(route.Properties["middlewares"].Items.Enum).Should(Equal([]interface{}{"limitMiddleware", "cors"}))
+
+			byMiddleware := route.Properties["params_by_middleware"]
+			Expect(byMiddleware.Properties).Should(HaveKey("cors"))
+			Expect(byMiddleware.Properties["cors"].Properties["enabled"].Type).Should(Equal("boolean"))
+			limit := byMiddleware.Properties["limitMiddleware"].Properties["limit"]
+			Expect(limit.Properties["max"].Type).Should(Equal("integer"))
+
+			flat := route.Properties["middleware_params"]
+			Expect(flat.Properties).Should(HaveKey("enabled"))
+			Expect(flat.Properties).Should(HaveKey("option"))
+			Expect(flat.Properties).Should(HaveKey("limit"))
+		})
+		It("should be marshallable to json", func() {
+			b, err := json.Marshal(schema)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).Should(ContainSubstring(`"$ref":"#/definitions/ProxyRoute"`))
+		})
+	})
+	Context("MiddlewareParamsJSONSchema", func() {
+		It("should lowercase name of fields without mapstructure tag as mapstructure does", func() {
+			schema := MiddlewareParamsJSONSchema(&untaggedParamsMiddleware{})
+			Expect(schema.Properties).Should(HaveKey("maxsize"))
+			Expect(schema.Properties).Should(HaveKey("tagged_name"))
+			Expect(schema.Properties).ShouldNot(HaveKey("MaxSize"))
+		})
+	})
+	Context("MiddlewareRegistry", func() {
+		It("should generate schema with registered middlewares", func() {
+			registry := NewMiddlewareRegistry()
+			registry.MustRegister("limit", func(_ map[string]interface{}) (MiddlewareHandler, error) {
+				return &limitMiddleware{}, nil
+			})
+			schema, err := registry.JSONSchema()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema.Definitions["EnabledMiddleware"].Properties["name"].Enum).Should(Equal([]interface{}{"limit"}))
+			Expect(schema.Definitions["ProxyRoute"].Properties["params_by_middleware"].Properties).Should(HaveKey("limit"))
+		})
+	})
+})
@@ -0,0 +1,200 @@
+package gobis
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Starter Middleware, or handler returned by a middleware, which must be started before serving requests
+// Middlewares are started once before their first handler is created, middlewares not used by any route are never started
+// Returned handlers are started just after their creation
+type Starter interface {
+	Start() error
+}
+
+// Closer Middleware, or handler returned by a middleware, which must release its resources (goroutines, connections, ...)
+// It is called when gobis handler is closed, handlers are closed before middlewares in reverse order of their creation
+type Closer interface {
+	Close() error
+}
+
+// SharedResourcesUser Middleware receiving resources shared by all routes of a gobis handler before its first handler is created
+// This should be used instead of package level singletons to let resources be released when gobis handler is closed
+type SharedResourcesUser interface {
+	UseSharedResources(resources *SharedResources)
+}
+
+// SharedResources Resources (caches, connections pools, ...) shared by all routes and middlewares of a gobis handler
+// Resources implementing Closer are closed with handler in reverse order of their creation
+type SharedResources struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+	keys   []string
+	closed bool
+}
+
+func NewSharedResources() *SharedResources {
+	return &SharedResources{
+		values: make(map[string]interface{}),
+	}
+}
+
+// Get Retrieve resource with this key, it is created with create function on first call
+// Middlewares should prefix keys with their name to avoid collisions
+func (s *SharedResources) Get(key string, create func() (interface{}, error)) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.values[key]; ok {
+		return value, nil
+	}
+	if s.closed {
+		return nil, fmt.Errorf("shared resource '%s' can't be created, resources are closed", key)
+	}
+	value, err := create()
+	if err != nil {
+		return nil, fmt.Errorf("shared resource '%s': %s", key, err.Error())
+	}
+	s.values[key] = value
+	s.keys = append(s.keys, key)
+	return value, nil
+}
+
+// Close Close resources implementing Closer in reverse order of their creation, first error is returned
+func (s *SharedResources) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var firstErr error
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		closer, ok := s.values[s.keys[i]].(Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("shared resource '%s': %s", s.keys[i], err.Error())
+		}
+	}
+	return firstErr
+}
+
+// lifecycle Keep track of middlewares and handlers which have been started and must be closed
+type lifecycle struct {
+	mu          sync.Mutex
+	started     map[int]bool
+	middlewares []MiddlewareHandler
+	handlers    []interface{}
+	closed      bool
+}
+
+// startMiddlewares Give shared resources to middlewares at these indexes and start them, each middleware is only started once
+// Middlewares are started in their order, those already started are closed with lifecycle even if one of them fails to start
+func (l *lifecycle) startMiddlewares(middlewares []MiddlewareHandler, indexes []int, resources *SharedResources) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return fmt.Errorf("router factory is closed")
+	}
+	if l.started == nil {
+		l.started = make(map[int]bool)
+	}
+	indexes = append([]int(nil), indexes...)
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		if l.started[index] {
+			continue
+		}
+		middleware := middlewares[index]
+		if user, ok := unwrapMiddleware(middleware).(SharedResourcesUser); ok && resources != nil {
+			user.UseSharedResources(resources)
+		}
+		if starter, ok := unwrapMiddleware(middleware).(Starter); ok {
+			if err := starter.Start(); err != nil {
+				return ErrMiddleware(fmt.Sprintf("Failed to start middleware %s: %s", MiddlewareName(middleware), err.Error()))
+			}
+		}
+		l.started[index] = true
+		l.middlewares = append(l.middlewares, middleware)
+	}
+	return nil
+}
+
+// track Start handler returned by a middleware and keep it to close it later, a handler already tracked is ignored
+func (l *lifecycle) track(handler interface{}) error {
+	starter, isStarter := handler.(Starter)
+	_, isCloser := handler.(Closer)
+	if !isStarter && !isCloser {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, tracked := range l.handlers {
+		if sameValue(tracked, handler) {
+			return nil
+		}
+	}
+	if isStarter {
+		if err := starter.Start(); err != nil {
+			return err
+		}
+	}
+	l.handlers = append(l.handlers, handler)
+	return nil
+}
+
+// close Close handlers and then middlewares in reverse order, first error is returned
+func (l *lifecycle) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	var firstErr error
+	for i := len(l.handlers) - 1; i >= 0; i-- {
+		closer, ok := l.handlers[i].(Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	l.handlers = nil
+	if err := l.closeMiddlewares(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func (l *lifecycle) closeMiddlewares() error {
+	var firstErr error
+	for i := len(l.middlewares) - 1; i >= 0; i-- {
+		closer, ok := unwrapMiddleware(l.middlewares[i]).(Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = ErrMiddleware(fmt.Sprintf("Failed to close middleware %s: %s", MiddlewareName(l.middlewares[i]), err.Error()))
+		}
+	}
+	return firstErr
+}
+
+// sameValue Compare two values without panicking on uncomparable types (e.g.: functions)
+func sameValue(a, b interface{}) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	typeA := reflect.TypeOf(a)
+	if typeA != reflect.TypeOf(b) || !typeA.Comparable() {
+		return false
+	}
+	return a == b
+}
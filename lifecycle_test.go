@@ -0,0 +1,185 @@
+package gobis_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type eventsRecorder struct {
+	events []string
+}
+
+func (r *eventsRecorder) record(format string, args ...interface{}) {
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+type recordedResource struct {
+	recorder *eventsRecorder
+}
+
+func (r *recordedResource) Close() error {
+	r.recorder.record("close resource")
+	return nil
+}
+
+// lifecycleHandler Handler returned by lifecycleMiddleware recording when it is started and closed
+type lifecycleHandler struct {
+	http.Handler
+	route    string
+	recorder *eventsRecorder
+}
+
+func (h *lifecycleHandler) Start() error {
+	h.recorder.record("start handler %s", h.route)
+	return nil
+}
+
+func (h *lifecycleHandler) Close() error {
+	h.recorder.record("close handler %s", h.route)
+	return nil
+}
+
+// lifecycleMiddleware Middleware recording when it is started and closed
+type lifecycleMiddleware struct {
+	recorder  *eventsRecorder
+	failStart bool
+	resources *SharedResources
+}
+
+func (m *lifecycleMiddleware) Start() error {
+	if m.failStart {
+		return fmt.Errorf("start failure")
+	}
+	m.recorder.record("start middleware")
+	return nil
+}
+
+func (m *lifecycleMiddleware) Close() error {
+	m.recorder.record("close middleware")
+	return nil
+}
+
+func (m *lifecycleMiddleware) UseSharedResources(resources *SharedResources) {
+	m.resources = resources
+}
+
+func (m *lifecycleMiddleware) Handler(route ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	_, err := m.resources.Get("lifecycle.store", func() (interface{}, error) {
+		m.recorder.record("create resource")
+		return &recordedResource{recorder: m.recorder}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lifecycleHandler{Handler: next, route: route.Name, recorder: m.recorder}, nil
+}
+
+func (m *lifecycleMiddleware) Schema() interface{} {
+	return struct{}{}
+}
+
+var _ = Describe("Lifecycle", func() {
+	var recorder *eventsRecorder
+	var routes []ProxyRoute
+	BeforeEach(func() {
+		recorder = &eventsRecorder{}
+		routes = []ProxyRoute{
+			{
+				Name: "app1",
+				Path: NewPathMatcher("/app1/**"),
+				Url:  "http://localhost",
+			},
+			{
+				Name: "app2",
+				Path: NewPathMatcher("/app2/**"),
+				Url:  "http://localhost",
+			},
+		}
+	})
+	It("should start and close middlewares, their handlers and shared resources", func() {
+		handler, err := NewDefaultHandler(DefaultHandlerConfig{Routes: routes}, &lifecycleMiddleware{recorder: recorder})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.events).Should(Equal([]string{
+			"start middleware",
+			"create resource",
+			"start handler app1",
+			"start handler app2",
+		}))
+
+		err = handler.(*DefaultHandler).Close()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.events[4:]).Should(Equal([]string{
+			"close handler app2",
+			"close handler app1",
+			"close middleware",
+			"close resource",
+		}))
+
+		err = handler.(*DefaultHandler).Close()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.events).Should(HaveLen(8))
+	})
+	It("should fail creating handler when a middleware can't start", func() {
+		_, err := NewDefaultHandler(DefaultHandlerConfig{Routes: routes},
+			&lifecycleMiddleware{recorder: recorder},
+			&lifecycleMiddleware{recorder: recorder, failStart: true},
+		)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).Should(ContainSubstring("start failure"))
+		Expect(recorder.events).Should(Equal([]string{"start middleware", "close middleware"}))
+	})
+	It("should not start middlewares which are not used by any route", func() {
+		for i := range routes {
+			routes[i].Middlewares = []string{}
+		}
+		handler, err := NewDefaultHandler(DefaultHandlerConfig{Routes: routes}, &lifecycleMiddleware{recorder: recorder})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(handler.(*DefaultHandler).Close()).To(Succeed())
+		Expect(recorder.events).Should(BeEmpty())
+	})
+	It("should let caller close its factory when handler can't be created", func() {
+		routes[1].Middlewares = []string{"unknown"}
+		factory := NewRouterFactory(&lifecycleMiddleware{recorder: recorder})
+		_, err := NewHandlerWithFactory(routes, factory)
+		Expect(err).To(HaveOccurred())
+		Expect(recorder.events).Should(Equal([]string{"start middleware", "create resource", "start handler app1"}))
+
+		Expect(factory.(*RouterFactoryService).Close()).To(Succeed())
+		Expect(recorder.events[3:]).Should(Equal([]string{"close handler app1", "close middleware", "close resource"}))
+	})
+	It("should close audit log file", func() {
+		dir, err := os.MkdirTemp("", "gobis-lifecycle")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		handler, err := NewDefaultHandler(DefaultHandlerConfig{Routes: routes, AuditLogFile: filepath.Join(dir, "audit.log")})
+		Expect(err).NotTo(HaveOccurred())
+		defaultHandler := handler.(*DefaultHandler)
+		Expect(defaultHandler.Close()).To(Succeed())
+		Expect(defaultHandler.Close()).To(Succeed())
+	})
+	Context("SharedResources", func() {
+		It("should create resources once and refuse new ones once closed", func() {
+			resources := NewSharedResources()
+			calls := 0
+			create := func() (interface{}, error) {
+				calls++
+				return calls, nil
+			}
+			value, err := resources.Get("key", create)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).Should(Equal(1))
+			value, err = resources.Get("key", create)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).Should(Equal(1))
+
+			Expect(resources.Close()).To(Succeed())
+			_, err = resources.Get("other", create)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -2,9 +2,41 @@ package gobis
 
 import (
 	"net/http"
+	"strings"
 )
 
 type MiddlewareHandler interface {
 	Handler(route ProxyRoute, params interface{}, next http.Handler) (http.Handler, error)
 	Schema() interface{}
 }
+
+// ResponseModifier Middleware which modifies responses received from upstream before they are written to client
+// Modifiers of route middlewares are called in reverse order (last middleware is first called), for buffered and streamed responses
+// Returning an error aborts the response, it is handled like an error received when calling upstream
+type ResponseModifier interface {
+	ModifyResponse(route ProxyRoute, params interface{}, req *http.Request, resp *http.Response) error
+}
+
+// NamedMiddlewareHandler Middleware giving its own name, name is used by routes to select middlewares
+// Name of type is used for middlewares which doesn't implement it
+type NamedMiddlewareHandler interface {
+	Name() string
+}
+
+// MiddlewareName Retrieve name of a middleware, routes use it in their middlewares option
+func MiddlewareName(middleware MiddlewareHandler) string {
+	if named, ok := middleware.(NamedMiddlewareHandler); ok {
+		return named.Name()
+	}
+	return GetMiddlewareName(middleware)
+}
+
+// findMiddleware Find index of a middleware by its name, case is ignored, -1 is returned when not found
+func findMiddleware(middlewares []MiddlewareHandler, name string) int {
+	for i, middleware := range middlewares {
+		if strings.EqualFold(MiddlewareName(middleware), name) {
+			return i
+		}
+	}
+	return -1
+}
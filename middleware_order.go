@@ -0,0 +1,117 @@
+package gobis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BeforeMiddlewares Middleware which must be called before middlewares with these names, if they are used
+type BeforeMiddlewares interface {
+	Before() []string
+}
+
+// AfterMiddlewares Middleware which must be called after middlewares with these names, if they are used
+type AfterMiddlewares interface {
+	After() []string
+}
+
+// RequiresMiddlewares Middleware which needs middlewares with these names to be used and called before it
+// e.g.: a middleware checking username requires the authentication middleware which calls SetUsername
+type RequiresMiddlewares interface {
+	Requires() []string
+}
+
+// SortMiddlewares Order middlewares according to their before, after and requires declarations (names are matched as in MiddlewareName)
+// Middlewares without constraints between them keep their given order, an error is returned on cycles or missing requirements
+func SortMiddlewares(middlewares []MiddlewareHandler) ([]MiddlewareHandler, error) {
+	// callAfter[i] Indexes of middlewares which must be called before middleware i
+	callAfter := make([]map[int]bool, len(middlewares))
+	for i := range callAfter {
+		callAfter[i] = make(map[int]bool)
+	}
+	for i, middleware := range middlewares {
+		name := MiddlewareName(middleware)
+		if requires, ok := unwrapMiddleware(middleware).(RequiresMiddlewares); ok {
+			for _, required := range requires.Requires() {
+				j := findMiddleware(middlewares, required)
+				if j < 0 {
+					return nil, ErrMiddleware(fmt.Sprintf("middleware '%s' requires middleware '%s' which is not used", name, required))
+				}
+				callAfter[i][j] = true
+			}
+		}
+		if after, ok := unwrapMiddleware(middleware).(AfterMiddlewares); ok {
+			for _, other := range after.After() {
+				if j := findMiddleware(middlewares, other); j >= 0 {
+					callAfter[i][j] = true
+				}
+			}
+		}
+		if before, ok := unwrapMiddleware(middleware).(BeforeMiddlewares); ok {
+			for _, other := range before.Before() {
+				if j := findMiddleware(middlewares, other); j >= 0 {
+					callAfter[j][i] = true
+				}
+			}
+		}
+	}
+
+	sorted := make([]MiddlewareHandler, 0, len(middlewares))
+	done := make([]bool, len(middlewares))
+	for len(sorted) < len(middlewares) {
+		next := -1
+		for i := range middlewares {
+			if !done[i] && allDone(callAfter[i], done) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, ErrMiddleware(fmt.Sprintf("middlewares have a dependency cycle: %s", describeCycle(middlewares, callAfter, done)))
+		}
+		done[next] = true
+		sorted = append(sorted, middlewares[next])
+	}
+	return sorted, nil
+}
+
+func allDone(indexes map[int]bool, done []bool) bool {
+	for i := range indexes {
+		if !done[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// describeCycle Find a cycle among middlewares not yet sorted, each of them waits for at least another one
+func describeCycle(middlewares []MiddlewareHandler, callAfter []map[int]bool, done []bool) string {
+	current := 0
+	for done[current] {
+		current++
+	}
+	visited := make(map[int]int)
+	path := make([]int, 0)
+	for {
+		if pos, ok := visited[current]; ok {
+			path = append(path[pos:], current)
+			break
+		}
+		visited[current] = len(path)
+		path = append(path, current)
+		waiting := make([]int, 0)
+		for j := range callAfter[current] {
+			if !done[j] {
+				waiting = append(waiting, j)
+			}
+		}
+		sort.Ints(waiting)
+		current = waiting[0]
+	}
+	steps := make([]string, len(path)-1)
+	for i := range steps {
+		steps[i] = fmt.Sprintf("'%s' must be called after '%s'", MiddlewareName(middlewares[path[i]]), MiddlewareName(middlewares[path[i+1]]))
+	}
+	return strings.Join(steps, ", ")
+}
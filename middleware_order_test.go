@@ -0,0 +1,139 @@
+package gobis_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+	"net/http/httptest"
+)
+
+// orderedMiddleware Trace middleware declaring its dependencies
+type orderedMiddleware struct {
+	traceMiddleware
+	before   []string
+	after    []string
+	requires []string
+}
+
+func (m orderedMiddleware) Before() []string {
+	return m.before
+}
+
+func (m orderedMiddleware) After() []string {
+	return m.after
+}
+
+func (m orderedMiddleware) Requires() []string {
+	return m.requires
+}
+
+func middlewaresNames(middlewares []MiddlewareHandler) []string {
+	names := make([]string, len(middlewares))
+	for i, middleware := range middlewares {
+		names[i] = MiddlewareName(middleware)
+	}
+	return names
+}
+
+var _ = Describe("SortMiddlewares", func() {
+	It("should keep given order when there is no dependency", func() {
+		sorted, err := SortMiddlewares([]MiddlewareHandler{
+			traceMiddleware{name: "first"},
+			traceMiddleware{name: "second"},
+			traceMiddleware{name: "third"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(middlewaresNames(sorted)).Should(Equal([]string{"first", "second", "third"}))
+	})
+	It("should order middlewares according to their dependencies", func() {
+		sorted, err := SortMiddlewares([]MiddlewareHandler{
+			orderedMiddleware{traceMiddleware: traceMiddleware{name: "acl"}, requires: []string{"Auth"}},
+			orderedMiddleware{traceMiddleware: traceMiddleware{name: "cors"}, before: []string{"auth", "unknown"}},
+			traceMiddleware{name: "auth"},
+			orderedMiddleware{traceMiddleware: traceMiddleware{name: "audit"}, after: []string{"acl", "unknown"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(middlewaresNames(sorted)).Should(Equal([]string{"cors", "auth", "acl", "audit"}))
+	})
+	It("should fail when a required middleware is missing", func() {
+		_, err := SortMiddlewares([]MiddlewareHandler{
+			orderedMiddleware{traceMiddleware: traceMiddleware{name: "acl"}, requires: []string{"auth"}},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).Should(Equal("middleware 'acl' requires middleware 'auth' which is not used"))
+	})
+	It("should fail on cycles", func() {
+		_, err := SortMiddlewares([]MiddlewareHandler{
+			traceMiddleware{name: "free"},
+			orderedMiddleware{traceMiddleware: traceMiddleware{name: "a"}, after: []string{"b"}},
+			orderedMiddleware{traceMiddleware: traceMiddleware{name: "b"}, after: []string{"c"}},
+			orderedMiddleware{traceMiddleware: traceMiddleware{name: "c"}, before: []string{"b"}, after: []string{"a"}},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).Should(Equal("middlewares have a dependency cycle: " +
+			"'a' must be called after 'b', 'b' must be called after 'c', 'c' must be called after 'a'"))
+	})
+	Context("handler", func() {
+		var routes []ProxyRoute
+		var backend *httptest.Server
+		var traces []string
+		BeforeEach(func() {
+			traces = nil
+			backend = httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				traces = req.Header.Values("X-Trace")
+			}))
+			routes = []ProxyRoute{
+				{
+					Name:    "app",
+					Path:    NewPathMatcher("/app/**"),
+					Url:     backend.URL,
+					NoProxy: true,
+				},
+			}
+		})
+		AfterEach(func() {
+			backend.Close()
+		})
+		It("should call middlewares in dependency order", func() {
+			handler, err := NewHandler(routes,
+				orderedMiddleware{traceMiddleware: traceMiddleware{name: "acl"}, requires: []string{"auth"}},
+				traceMiddleware{name: "auth"},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost/app/path", nil))
+			Expect(recorder.Code).Should(Equal(http.StatusOK))
+			Expect(traces).Should(Equal([]string{"auth", "acl"}))
+		})
+		It("should fail when middlewares of a route miss a requirement", func() {
+			routes[0].Middlewares = []string{"acl"}
+			_, err := NewHandler(routes,
+				orderedMiddleware{traceMiddleware: traceMiddleware{name: "acl"}, requires: []string{"auth"}},
+				traceMiddleware{name: "auth"},
+			)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).Should(Equal("route 'app': middleware 'acl' requires middleware 'auth' which is not used"))
+		})
+		It("should order middlewares in router factory", func() {
+			rtr, err := NewRouterFactory(
+				orderedMiddleware{traceMiddleware: traceMiddleware{name: "acl"}, requires: []string{"auth"}},
+				traceMiddleware{name: "auth"},
+			).CreateMuxRouter(routes, "")
+			Expect(err).NotTo(HaveOccurred())
+			recorder := httptest.NewRecorder()
+			rtr.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost/app/path", nil))
+			Expect(recorder.Code).Should(Equal(http.StatusOK))
+			Expect(traces).Should(Equal([]string{"auth", "acl"}))
+		})
+		It("should fail in router factory when middlewares have a cycle", func() {
+			_, err := NewRouterFactory(
+				orderedMiddleware{traceMiddleware: traceMiddleware{name: "a"}, after: []string{"b"}},
+				orderedMiddleware{traceMiddleware: traceMiddleware{name: "b"}, after: []string{"a"}},
+			).CreateMuxRouter(routes, "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).Should(Equal("route 'app': middlewares have a dependency cycle: " +
+				"'a' must be called after 'b', 'b' must be called after 'a'"))
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package gobis
+
+import (
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ParamsValidator Schema of a middleware can implement it to check params after they have been decoded
+type ParamsValidator interface {
+	Validate() error
+}
+
+// decodeMiddlewaresParams Decode params of route for each middleware, in strict mode params not used by any middleware are refused
+func (r RouterFactoryService) decodeMiddlewaresParams(proxyRoute ProxyRoute, middlewares []MiddlewareHandler) ([]interface{}, error) {
+	params := make([]interface{}, len(middlewares))
+	unused := make([][]string, len(middlewares))
+	for i, middleware := range middlewares {
+		var err error
+		params[i], unused[i], err = paramsToSchema(middlewareParams(proxyRoute, middleware), middleware.Schema())
+		if err != nil {
+			return nil, ErrMiddleware(fmt.Sprintf(
+				"route '%s': middleware '%s': invalid params: %s",
+				proxyRoute.Name, MiddlewareName(middleware), err.Error(),
+			))
+		}
+	}
+	if !r.StrictParams {
+		return params, nil
+	}
+	err := checkUnknownParams(proxyRoute, middlewares, unused)
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// paramsToSchema Decode params in a new value of schema type, keys of params not used by schema are returned
+// Decoded value is validated when schema implements ParamsValidator
+func paramsToSchema(params interface{}, schema interface{}) (interface{}, []string, error) {
+	if params != nil && reflect.TypeOf(params).Kind() != reflect.Map {
+		params = InterfaceToMap(params)
+	}
+	val := reflect.New(reflect.TypeOf(schema))
+	var metadata mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Metadata: &metadata,
+		Result:   val.Interface(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	err = decoder.Decode(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if validator, ok := val.Interface().(ParamsValidator); ok {
+		err = validator.Validate()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.Strings(metadata.Unused)
+	return val.Elem().Interface(), metadata.Unused, nil
+}
+
+// checkUnknownParams Refuse params given only to a middleware and not used by it
+// and params given to all middlewares not used by any of them
+func checkUnknownParams(proxyRoute ProxyRoute, middlewares []MiddlewareHandler, unused [][]string) error {
+	flatKeys := make([]string, 0)
+	for i, middleware := range middlewares {
+		name := MiddlewareName(middleware)
+		namespaced := toStringKeyMap(namespacedParams(proxyRoute, name))
+		for _, key := range unused[i] {
+			if _, ok := namespaced[strings.Split(key, ".")[0]]; ok {
+				return ErrMiddleware(fmt.Sprintf("route '%s': middleware '%s': unknown param '%s'", proxyRoute.Name, name, key))
+			}
+			flatKeys = append(flatKeys, key)
+		}
+	}
+	if len(middlewares) == 0 {
+		for key := range toStringKeyMap(proxyRoute.MiddlewareParams) {
+			flatKeys = append(flatKeys, key)
+		}
+	}
+	sort.Strings(flatKeys)
+	for _, key := range flatKeys {
+		usedBy := false
+		for i := range middlewares {
+			if !isUnusedKey(unused[i], key) {
+				usedBy = true
+				break
+			}
+		}
+		if !usedBy {
+			return ErrMiddleware(fmt.Sprintf("route '%s': param '%s' is not used by any middleware", proxyRoute.Name, key))
+		}
+	}
+	return nil
+}
+
+// isUnusedKey Check if key, or one of its parents, is in list of unused keys
+func isUnusedKey(unused []string, key string) bool {
+	for _, unusedKey := range unused {
+		if key == unusedKey || strings.HasPrefix(key, unusedKey+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// warnUnknownParams Warn about params given to a middleware unknown by factory
+func (r RouterFactoryService) warnUnknownParams(proxyRoute ProxyRoute) {
+	for name := range proxyRoute.ParamsByMiddleware {
+		if findMiddleware(r.MiddlewareHandlers, name) < 0 {
+			log.WithField("route_name", proxyRoute.Name).
+				Warnf("orange-cloudfoundry/gobis/proxy: params are given to middleware '%s' which is not registered", name)
+		}
+	}
+}
+
+// namespacedParams Retrieve params given only to middleware with this name, nil is returned if there is none
+func namespacedParams(proxyRoute ProxyRoute, name string) interface{} {
+	for key, value := range proxyRoute.ParamsByMiddleware {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return nil
+}
+
+// middlewareParams Merge params of route with params given only to this middleware
+func middlewareParams(proxyRoute ProxyRoute, middleware MiddlewareHandler) interface{} {
+	namespaced := namespacedParams(proxyRoute, MiddlewareName(middleware))
+	if namespaced == nil {
+		return proxyRoute.MiddlewareParams
+	}
+	params := toStringKeyMap(proxyRoute.MiddlewareParams)
+	for key, value := range toStringKeyMap(namespaced) {
+		params[key] = value
+	}
+	return params
+}
+
+// toStringKeyMap Convert params (a structure or a map decoded from json or yaml) to a map with string keys
+func toStringKeyMap(params interface{}) map[string]interface{} {
+	switch p := params.(type) {
+	case nil:
+		return make(map[string]interface{})
+	case map[string]interface{}:
+		return mergeMap(make(map[string]interface{}), p)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, value := range p {
+			m[fmt.Sprint(key)] = value
+		}
+		return m
+	}
+	return InterfaceToMap(params)
+}
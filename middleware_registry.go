@@ -0,0 +1,181 @@
+package gobis
+
+import (
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MiddlewareFactory Create a middleware from its global options found in handler config
+type MiddlewareFactory func(options map[string]interface{}) (MiddlewareHandler, error)
+
+// EnabledMiddleware A middleware to create from a registry with its global options
+type EnabledMiddleware struct {
+	// Name Name used to register middleware
+	Name string `json:"name" yaml:"name"`
+	// Options Global options given to middleware factory
+	Options map[string]interface{} `json:"options" yaml:"options"`
+}
+
+// MiddlewareRegistry List of middleware factories by name, it lets handlers be built only from configuration
+type MiddlewareRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]registeredMiddleware
+}
+
+type registeredMiddleware struct {
+	name    string
+	factory MiddlewareFactory
+}
+
+// DefaultMiddlewareRegistry Registry used by RegisterMiddleware, middlewares packages should register themselves in it in an init function
+var DefaultMiddlewareRegistry = NewMiddlewareRegistry()
+
+func NewMiddlewareRegistry() *MiddlewareRegistry {
+	return &MiddlewareRegistry{
+		factories: make(map[string]registeredMiddleware),
+	}
+}
+
+// RegisterMiddleware Register a middleware factory in default registry
+func RegisterMiddleware(name string, factory MiddlewareFactory) error {
+	return DefaultMiddlewareRegistry.Register(name, factory)
+}
+
+// Register Register a middleware factory by its name, names are case insensitive and can't be registered twice
+func (r *MiddlewareRegistry) Register(name string, factory MiddlewareFactory) error {
+	if name == "" {
+		return fmt.Errorf("middleware name can't be empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("factory of middleware '%s' can't be nil", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(name)
+	if _, ok := r.factories[key]; ok {
+		return fmt.Errorf("middleware '%s' is already registered", name)
+	}
+	r.factories[key] = registeredMiddleware{name: name, factory: factory}
+	return nil
+}
+
+// MustRegister Register a middleware factory and panic on error
+func (r *MiddlewareRegistry) MustRegister(name string, factory MiddlewareFactory) {
+	if err := r.Register(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Names List of registered middlewares names sorted alphabetically
+func (r *MiddlewareRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for _, registered := range r.factories {
+		names = append(names, registered.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create Create a middleware from registry, created middleware is named with name used to register it
+func (r *MiddlewareRegistry) Create(enabled EnabledMiddleware) (MiddlewareHandler, error) {
+	r.mu.RLock()
+	registered, ok := r.factories[strings.ToLower(enabled.Name)]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown middleware '%s', registered middlewares: %s", enabled.Name, strings.Join(r.Names(), ", "))
+	}
+	options := enabled.Options
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	middleware, err := registered.factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create middleware '%s': %s", enabled.Name, err.Error())
+	}
+	if strings.EqualFold(MiddlewareName(middleware), registered.name) {
+		return middleware, nil
+	}
+	return &namedMiddleware{MiddlewareHandler: middleware, name: registered.name}, nil
+}
+
+// CreateAll Create middlewares in the order they are given
+func (r *MiddlewareRegistry) CreateAll(enabled []EnabledMiddleware) ([]MiddlewareHandler, error) {
+	middlewares := make([]MiddlewareHandler, len(enabled))
+	for i, e := range enabled {
+		var err error
+		middlewares[i], err = r.Create(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return middlewares, nil
+}
+
+// JSONSchema Generate a JSON Schema of DefaultHandlerConfig with params of all registered middlewares
+// Middlewares are created with empty options to retrieve their schema
+func (r *MiddlewareRegistry) JSONSchema() (*JSONSchema, error) {
+	names := r.Names()
+	enabled := make([]EnabledMiddleware, len(names))
+	enum := make([]interface{}, len(names))
+	for i, name := range names {
+		enabled[i] = EnabledMiddleware{Name: name}
+		enum[i] = name
+	}
+	middlewares, err := r.CreateAll(enabled)
+	if err != nil {
+		return nil, err
+	}
+	schema := GenerateJSONSchema(middlewares...)
+	enabledSchema := schema.Definitions["EnabledMiddleware"]
+	if enabledSchema != nil {
+		enabledSchema.Properties["name"].Enum = enum
+		enabledSchema.Required = []string{"name"}
+	}
+	return schema, nil
+}
+
+// DecodeMiddlewareOptions Decode options given to a middleware factory into a structure
+func DecodeMiddlewareOptions(options map[string]interface{}, target interface{}) error {
+	return mapstructure.Decode(options, target)
+}
+
+// NewDefaultHandlerFromRegistry Create a handler with middlewares listed in EnabledMiddlewares of config
+// Middlewares are created from registry, DefaultMiddlewareRegistry is used when registry is nil
+func NewDefaultHandlerFromRegistry(config DefaultHandlerConfig, registry *MiddlewareRegistry) (GobisHandler, error) {
+	if registry == nil {
+		registry = DefaultMiddlewareRegistry
+	}
+	middlewares, err := registry.CreateAll(config.EnabledMiddlewares)
+	if err != nil {
+		return nil, err
+	}
+	return NewDefaultHandler(config, middlewares...)
+}
+
+// namedMiddleware Give name used in registry to a middleware
+type namedMiddleware struct {
+	MiddlewareHandler
+	name string
+}
+
+func (m namedMiddleware) Name() string {
+	return m.name
+}
+
+// Unwrap Retrieve middleware created by factory
+func (m namedMiddleware) Unwrap() MiddlewareHandler {
+	return m.MiddlewareHandler
+}
+
+// unwrapMiddleware Retrieve middleware created by factory to check optional interfaces it implements
+func unwrapMiddleware(middleware MiddlewareHandler) MiddlewareHandler {
+	if named, ok := middleware.(*namedMiddleware); ok {
+		return named.MiddlewareHandler
+	}
+	return middleware
+}
@@ -0,0 +1,72 @@
+package gobis_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("MiddlewareRegistry", func() {
+	var registry *MiddlewareRegistry
+	BeforeEach(func() {
+		registry = NewMiddlewareRegistry()
+		registry.MustRegister("trace", func(options map[string]interface{}) (MiddlewareHandler, error) {
+			var opts struct {
+				Name string `mapstructure:"name"`
+			}
+			err := DecodeMiddlewareOptions(options, &opts)
+			if err != nil {
+				return nil, err
+			}
+			return &traceMiddleware{name: opts.Name}, nil
+		})
+		registry.MustRegister("groups", func(_ map[string]interface{}) (MiddlewareHandler, error) {
+			return &groupsMiddleware{}, nil
+		})
+	})
+	It("should refuse to register a name twice", func() {
+		err := registry.Register("Groups", func(_ map[string]interface{}) (MiddlewareHandler, error) {
+			return &groupsMiddleware{}, nil
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(registry.Names()).Should(Equal([]string{"groups", "trace"}))
+	})
+	It("should give an error listing registered middlewares when a middleware is unknown", func() {
+		_, err := registry.Create(EnabledMiddleware{Name: "cors"})
+		Expect(err).To(MatchError("unknown middleware 'cors', registered middlewares: groups, trace"))
+	})
+	It("should name created middlewares with name used to register them", func() {
+		middleware, err := registry.Create(EnabledMiddleware{Name: "groups"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(MiddlewareName(middleware)).Should(Equal("groups"))
+	})
+	It("should create a handler from configuration", func() {
+		var trace []string
+		var groups []string
+		handler, err := NewDefaultHandlerFromRegistry(DefaultHandlerConfig{
+			EnabledMiddlewares: []EnabledMiddleware{
+				{Name: "groups"},
+				{Name: "trace", Options: map[string]interface{}{"name": "trace"}},
+			},
+			Routes: []ProxyRoute{
+				{
+					Name:        "app",
+					Path:        NewPathMatcher("/app/**"),
+					Middlewares: []string{"trace", "groups"},
+					ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+						trace = req.Header.Values("X-Trace")
+						groups = Groups(req)
+					}),
+				},
+			},
+		}, registry)
+		Expect(err).NotTo(HaveOccurred())
+		req := httptest.NewRequest("GET", "http://localhost/app/path", nil)
+		req.Header.Set("X-Test-Group", "admin")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(trace).Should(Equal([]string{"trace"}))
+		Expect(groups).Should(Equal([]string{"admin"}))
+	})
+})
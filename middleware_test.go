@@ -0,0 +1,394 @@
+package gobis_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"io"
+	"net/http"
+	"net/http/httptest"
+)
+
+// traceMiddleware Middleware adding its name to header X-Trace to follow calls order
+type traceMiddleware struct {
+	name string
+}
+
+func (m traceMiddleware) Name() string {
+	return m.name
+}
+
+func (m traceMiddleware) Handler(_ ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Add("X-Trace", m.name)
+		next.ServeHTTP(w, req)
+	}), nil
+}
+
+func (traceMiddleware) Schema() interface{} {
+	return struct{}{}
+}
+
+type enabledParams struct {
+	Enabled bool   `json:"enabled" mapstructure:"enabled"`
+	Option  string `json:"option" mapstructure:"option"`
+}
+
+// paramsMiddleware Middleware keeping params it received
+type paramsMiddleware struct {
+	name   string
+	params *enabledParams
+}
+
+func (m paramsMiddleware) Name() string {
+	return m.name
+}
+
+func (m paramsMiddleware) Handler(_ ProxyRoute, params interface{}, next http.Handler) (http.Handler, error) {
+	*m.params = params.(enabledParams)
+	return next, nil
+}
+
+func (paramsMiddleware) Schema() interface{} {
+	return enabledParams{}
+}
+
+type limitParams struct {
+	Limit struct {
+		Max int `mapstructure:"max"`
+	} `mapstructure:"limit"`
+}
+
+func (p *limitParams) Validate() error {
+	if p.Limit.Max < 0 {
+		return fmt.Errorf("limit.max must be positive")
+	}
+	return nil
+}
+
+// limitMiddleware Middleware with a nested schema which validates its params
+type limitMiddleware struct{}
+
+func (limitMiddleware) Handler(_ ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	return next, nil
+}
+
+func (limitMiddleware) Schema() interface{} {
+	return limitParams{}
+}
+
+// modifierMiddleware Middleware adding its name to header X-Modified of upstream responses
+type modifierMiddleware struct {
+	name string
+}
+
+func (m modifierMiddleware) Name() string {
+	return m.name
+}
+
+func (modifierMiddleware) Handler(_ ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	return next, nil
+}
+
+func (modifierMiddleware) Schema() interface{} {
+	return enabledParams{}
+}
+
+func (m modifierMiddleware) ModifyResponse(route ProxyRoute, params interface{}, req *http.Request, resp *http.Response) error {
+	if params.(enabledParams).Option == "fail" {
+		return fmt.Errorf("modifier failed")
+	}
+	resp.Header.Add("X-Modified", m.name)
+	resp.Header.Set("X-Route", route.Name+":"+RouteName(req))
+	return nil
+}
+
+// validatedParams Params counting how many times they are validated
+type validatedParams struct {
+	Option string `mapstructure:"option"`
+}
+
+var validatedParamsCalls int
+
+func (p *validatedParams) Validate() error {
+	validatedParamsCalls++
+	return nil
+}
+
+// validatedModifierMiddleware Response modifier middleware with params counting their decoding
+type validatedModifierMiddleware struct{}
+
+func (validatedModifierMiddleware) Handler(_ ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	return next, nil
+}
+
+func (validatedModifierMiddleware) Schema() interface{} {
+	return validatedParams{}
+}
+
+func (validatedModifierMiddleware) ModifyResponse(_ ProxyRoute, _ interface{}, _ *http.Request, _ *http.Response) error {
+	return nil
+}
+
+var _ = Describe("Middleware", func() {
+	Context("MiddlewareName", func() {
+		It("should use name given by middleware or its type name", func() {
+			Expect(MiddlewareName(&traceMiddleware{name: "auth"})).Should(Equal("auth"))
+			Expect(MiddlewareName(&groupsMiddleware{})).Should(Equal("groupsMiddleware"))
+		})
+	})
+	Context("Route middlewares", func() {
+		var trace []string
+		middlewares := []MiddlewareHandler{
+			&traceMiddleware{name: "auth"},
+			&traceMiddleware{name: "cors"},
+			&traceMiddleware{name: "rate-limit"},
+		}
+		serve := func(config DefaultHandlerConfig, path string) {
+			trace = nil
+			handler, err := NewDefaultHandler(config, middlewares...)
+			Expect(err).NotTo(HaveOccurred())
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost"+path, nil))
+		}
+		forward := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			trace = req.Header.Values("X-Trace")
+		})
+		routes := []ProxyRoute{
+			{
+				Name:           "all",
+				Path:           NewPathMatcher("/all/**"),
+				ForwardHandler: forward,
+			},
+			{
+				Name:           "selected",
+				Path:           NewPathMatcher("/selected/**"),
+				Middlewares:    []string{"rate-limit", "Auth"},
+				ForwardHandler: forward,
+			},
+			{
+				Name:           "none",
+				Path:           NewPathMatcher("/none/**"),
+				Middlewares:    []string{},
+				ForwardHandler: forward,
+			},
+		}
+		It("should use all middlewares when route doesn't list them", func() {
+			serve(DefaultHandlerConfig{Routes: routes}, "/all/path")
+			Expect(trace).Should(Equal([]string{"auth", "cors", "rate-limit"}))
+		})
+		It("should use middlewares listed by route in their order", func() {
+			serve(DefaultHandlerConfig{Routes: routes}, "/selected/path")
+			Expect(trace).Should(Equal([]string{"rate-limit", "auth"}))
+
+			serve(DefaultHandlerConfig{Routes: routes}, "/none/path")
+			Expect(trace).Should(BeEmpty())
+		})
+		It("should use handler middlewares for routes which doesn't list them", func() {
+			config := DefaultHandlerConfig{
+				Routes:      routes,
+				Middlewares: []string{"cors"},
+			}
+			serve(config, "/all/path")
+			Expect(trace).Should(Equal([]string{"cors"}))
+
+			serve(config, "/selected/path")
+			Expect(trace).Should(Equal([]string{"rate-limit", "auth"}))
+		})
+		It("should give an error with route name when a middleware is unknown or listed twice", func() {
+			_, err := NewDefaultHandler(DefaultHandlerConfig{
+				Routes: []ProxyRoute{
+					{
+						Name:           "app",
+						Path:           NewPathMatcher("/app/**"),
+						Middlewares:    []string{"auth", "unknown"},
+						ForwardHandler: forward,
+					},
+				},
+			}, middlewares...)
+			Expect(err).To(MatchError("route 'app': unknown middleware 'unknown'"))
+
+			_, err = NewDefaultHandler(DefaultHandlerConfig{
+				Routes: []ProxyRoute{
+					{
+						Name:           "app",
+						Path:           NewPathMatcher("/app/**"),
+						Middlewares:    []string{"auth", "AUTH"},
+						ForwardHandler: forward,
+					},
+				},
+			}, middlewares...)
+			Expect(err).To(MatchError("route 'app': middleware 'AUTH' is listed more than once"))
+		})
+	})
+	Context("Params by middleware", func() {
+		var corsParams, authParams enabledParams
+		var factory RouterFactory
+		BeforeEach(func() {
+			corsParams = enabledParams{}
+			authParams = enabledParams{}
+			factory = NewRouterFactory(
+				&paramsMiddleware{name: "cors", params: &corsParams},
+				&paramsMiddleware{name: "auth", params: &authParams},
+			)
+		})
+		route := func() ProxyRoute {
+			return ProxyRoute{
+				Name:           "app",
+				Path:           NewPathMatcher("/app/**"),
+				ForwardHandler: http.NotFoundHandler(),
+			}
+		}
+		It("should give flat params to every middleware", func() {
+			rte := route()
+			rte.MiddlewareParams = map[string]interface{}{"enabled": true}
+			_, err := factory.CreateForwardHandler(rte)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(corsParams.Enabled).Should(BeTrue())
+			Expect(authParams.Enabled).Should(BeTrue())
+		})
+		It("should give namespaced params only to their middleware and take precedence over flat params", func() {
+			rte := route()
+			rte.MiddlewareParams = map[string]interface{}{"option": "flat"}
+			rte.ParamsByMiddleware = map[string]interface{}{
+				"cors": map[interface{}]interface{}{"enabled": true},
+				"Auth": enabledParams{Enabled: false, Option: "auth"},
+			}
+			_, err := factory.CreateForwardHandler(rte)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(corsParams).Should(Equal(enabledParams{Enabled: true, Option: "flat"}))
+			Expect(authParams).Should(Equal(enabledParams{Enabled: false, Option: "auth"}))
+		})
+		It("should warn when params are given to a middleware which is not registered", func() {
+			hooks := log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			defer log.StandardLogger().ReplaceHooks(hooks)
+			hook := test.NewGlobal()
+			rte := route()
+			rte.ParamsByMiddleware = map[string]interface{}{
+				"unknown": map[string]interface{}{"enabled": true},
+			}
+			_, err := factory.CreateForwardHandler(rte)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hook.LastEntry()).ShouldNot(BeNil())
+			Expect(hook.LastEntry().Level).Should(Equal(log.WarnLevel))
+			Expect(hook.LastEntry().Message).Should(ContainSubstring("'unknown'"))
+		})
+	})
+	Context("Params decoding", func() {
+		create := func(strict bool, params interface{}, byMiddleware map[string]interface{}) error {
+			factory := NewRouterFactory(&limitMiddleware{}, &paramsMiddleware{name: "cors", params: &enabledParams{}}).(*RouterFactoryService)
+			factory.StrictParams = strict
+			_, err := factory.CreateForwardHandler(ProxyRoute{
+				Name:               "app",
+				Path:               NewPathMatcher("/app/**"),
+				MiddlewareParams:   params,
+				ParamsByMiddleware: byMiddleware,
+				ForwardHandler:     http.NotFoundHandler(),
+			})
+			return err
+		}
+		It("should give an error with route, middleware and key path when params can't be decoded", func() {
+			err := create(false, map[string]interface{}{
+				"limit": map[string]interface{}{"max": "many"},
+			}, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("route 'app': middleware 'limitMiddleware': invalid params:"))
+			Expect(err.Error()).Should(ContainSubstring("'limit.max'"))
+		})
+		It("should give an error when params are not valid", func() {
+			err := create(false, map[string]interface{}{
+				"limit": map[string]interface{}{"max": -1},
+			}, nil)
+			Expect(err).To(MatchError("route 'app': middleware 'limitMiddleware': invalid params: limit.max must be positive"))
+		})
+		It("should accept params used by at least one middleware in strict mode", func() {
+			err := create(true, map[string]interface{}{
+				"limit":   map[string]interface{}{"max": 1},
+				"enabled": true,
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should ignore unknown params when not in strict mode", func() {
+			err := create(false, map[string]interface{}{
+				"limit": map[string]interface{}{"mx": 1},
+			}, map[string]interface{}{
+				"cors": map[string]interface{}{"unknown": true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should refuse params not used by any middleware in strict mode", func() {
+			err := create(true, map[string]interface{}{
+				"limit": map[string]interface{}{"mx": 1},
+			}, nil)
+			Expect(err).To(MatchError("route 'app': param 'limit.mx' is not used by any middleware"))
+
+			err = create(true, map[string]interface{}{
+				"enable": true,
+			}, nil)
+			Expect(err).To(MatchError("route 'app': param 'enable' is not used by any middleware"))
+		})
+		It("should refuse params by middleware not used by their middleware in strict mode", func() {
+			err := create(true, nil, map[string]interface{}{
+				"cors": map[string]interface{}{"enabled": true, "limit": 1},
+			})
+			Expect(err).To(MatchError("route 'app': middleware 'cors': unknown param 'limit'"))
+		})
+	})
+	Context("Response modifiers", func() {
+		var backend *httptest.Server
+		BeforeEach(func() {
+			backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				//nolint:errcheck
+				w.Write([]byte("backend"))
+			}))
+		})
+		AfterEach(func() {
+			backend.Close()
+		})
+		serve := func(noBuffer bool, params interface{}) *http.Response {
+			handler, err := NewHandler([]ProxyRoute{
+				{
+					Name:             "app",
+					Path:             NewPathMatcher("/app/**"),
+					Url:              backend.URL,
+					NoProxy:          true,
+					NoBuffer:         noBuffer,
+					MiddlewareParams: params,
+				},
+			}, &modifierMiddleware{name: "first"}, &traceMiddleware{name: "trace"}, &modifierMiddleware{name: "second"})
+			Expect(err).NotTo(HaveOccurred())
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost/app/path", nil))
+			return recorder.Result()
+		}
+		It("should call modifiers in reverse order on buffered and streamed responses", func() {
+			for _, noBuffer := range []bool{false, true} {
+				resp := serve(noBuffer, nil)
+				Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+				Expect(resp.Header.Values("X-Modified")).Should(Equal([]string{"second", "first"}))
+				Expect(resp.Header.Get("X-Route")).Should(Equal("app:app"))
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).Should(Equal("backend"))
+			}
+		})
+		It("should answer with an error when a modifier fails", func() {
+			resp := serve(true, map[string]interface{}{"option": "fail"})
+			Expect(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+		})
+		It("should decode params once for middlewares and response modifiers", func() {
+			validatedParamsCalls = 0
+			_, err := NewRouterFactory(&validatedModifierMiddleware{}).CreateForwardHandler(ProxyRoute{
+				Name:             "app",
+				Path:             NewPathMatcher("/app/**"),
+				Url:              backend.URL,
+				NoProxy:          true,
+				MiddlewareParams: map[string]interface{}{"option": "value"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(validatedParamsCalls).Should(Equal(1))
+		})
+	})
+})
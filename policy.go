@@ -0,0 +1,506 @@
+package gobis
+
+import (
+	"fmt"
+	"github.com/gobwas/glob"
+	log "github.com/sirupsen/logrus"
+	"github.com/vulcand/predicate"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type PolicyEffect string
+
+const (
+	PolicyAllow PolicyEffect = "allow"
+	PolicyDeny  PolicyEffect = "deny"
+)
+
+// PolicyRule A rule of a policy, effect is applied when expression in When matches the request
+// Expressions use go syntax with these functions:
+//   - Method(), Path(), RoutePath() (path after route path, see Path), RouteName(), ClientIP(), Username(): give a string
+//   - Header(name), Claim(name) (see Principal.Claim), PathSegment(index) (0 is first segment of request path): give a string
+//   - Hour(), Weekday() (0 is sunday): give an int in policy timezone
+//   - InGroup(pattern), PathMatch(pattern), RoutePathMatch(pattern): match groups (and roles) or paths with glob patterns (e.g.: /admin/**)
+//   - BusinessHours(start, end): true from monday to friday when start <= Hour() < end
+//
+// Strings and ints can be compared with ==, !=, <, >, <= and >=, expressions are combined with &&, || and !
+// e.g.: Method() == "POST" && PathMatch("/admin/**") && InGroup("ops") && BusinessHours(9, 18)
+type PolicyRule struct {
+	// Name Name of the rule written in audit log
+	Name string `json:"name" yaml:"name"`
+	// Effect allow or deny
+	Effect PolicyEffect `json:"effect" yaml:"effect"`
+	// When Expression which must match to apply effect, rule always matches when empty
+	When string `json:"when" yaml:"when"`
+}
+
+// Policy Ordered list of rules evaluated for each request, first rule matching gives decision
+type Policy struct {
+	// Default Effect applied when no rule matches: allow or deny (Default: deny)
+	Default PolicyEffect `json:"default" yaml:"default"`
+	// Timezone Timezone used by time functions, e.g.: Europe/Paris (Default: local timezone)
+	Timezone string `json:"timezone" yaml:"timezone"`
+	// Rules List of rules
+	Rules []PolicyRule `json:"rules" yaml:"rules"`
+}
+
+// PolicyDecision Result of a policy evaluation
+type PolicyDecision struct {
+	// Effect Effect applied on request
+	Effect PolicyEffect
+	// Rule Name of the rule which has matched, empty when default effect was applied
+	Rule string
+}
+
+// LoadPolicyFile Load a policy from a yaml or json file
+func LoadPolicyFile(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy file: %s", err.Error())
+	}
+	var policy Policy
+	// yaml is a superset of json
+	err = yaml.Unmarshal(b, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %s", path, err.Error())
+	}
+	err = policy.Check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %s", path, err.Error())
+	}
+	return &policy, nil
+}
+
+func (p Policy) Check() error {
+	_, err := p.compile()
+	return err
+}
+
+// Evaluate Evaluate policy against a request
+func (p Policy) Evaluate(req *http.Request) (PolicyDecision, error) {
+	compiled, err := p.compile()
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	return compiled.evaluate(req), nil
+}
+
+type policyContext struct {
+	req *http.Request
+	now time.Time
+}
+
+type policyPredicate func(c *policyContext) bool
+
+type policyString func(c *policyContext) string
+
+type policyInt func(c *policyContext) int
+
+type compiledPolicyRule struct {
+	name   string
+	effect PolicyEffect
+	when   policyPredicate
+}
+
+type compiledPolicy struct {
+	defaultEffect PolicyEffect
+	location      *time.Location
+	rules         []compiledPolicyRule
+}
+
+func (p Policy) compile() (*compiledPolicy, error) {
+	compiled := &compiledPolicy{
+		defaultEffect: p.Default,
+		location:      time.Local,
+		rules:         make([]compiledPolicyRule, len(p.Rules)),
+	}
+	if compiled.defaultEffect == "" {
+		compiled.defaultEffect = PolicyDeny
+	}
+	if err := checkPolicyEffect(compiled.defaultEffect); err != nil {
+		return nil, fmt.Errorf("invalid default: %s", err.Error())
+	}
+	if p.Timezone != "" {
+		var err error
+		compiled.location, err = time.LoadLocation(p.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %s", err.Error())
+		}
+	}
+	for i, rule := range p.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i)
+		}
+		if err := checkPolicyEffect(rule.Effect); err != nil {
+			return nil, fmt.Errorf("%s: invalid effect: %s", name, err.Error())
+		}
+		when, err := parsePolicyExpression(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid expression '%s': %s", name, rule.When, err.Error())
+		}
+		compiled.rules[i] = compiledPolicyRule{
+			name:   name,
+			effect: rule.Effect,
+			when:   when,
+		}
+	}
+	return compiled, nil
+}
+
+func checkPolicyEffect(effect PolicyEffect) error {
+	if effect != PolicyAllow && effect != PolicyDeny {
+		return fmt.Errorf("must be allow or deny")
+	}
+	return nil
+}
+
+func (p *compiledPolicy) evaluate(req *http.Request) PolicyDecision {
+	c := &policyContext{
+		req: req,
+		now: time.Now().In(p.location),
+	}
+	for _, rule := range p.rules {
+		if rule.when(c) {
+			return PolicyDecision{Effect: rule.effect, Rule: rule.name}
+		}
+	}
+	return PolicyDecision{Effect: p.defaultEffect}
+}
+
+func parsePolicyExpression(expr string) (policyPredicate, error) {
+	if strings.TrimSpace(expr) == "" {
+		return func(c *policyContext) bool {
+			return true
+		}, nil
+	}
+	p, err := predicate.NewParser(predicate.Def{
+		Operators: predicate.Operators{
+			AND: policyAnd,
+			OR:  policyOr,
+			NOT: policyNot,
+			EQ:  policyEq,
+			NEQ: policyNeq,
+			LT:  policyLt,
+			GT:  policyGt,
+			LE:  policyLe,
+			GE:  policyGe,
+		},
+		Functions: map[string]interface{}{
+			"Method":         policyMethod,
+			"Path":           policyPath,
+			"RoutePath":      policyRoutePath,
+			"RouteName":      policyRouteName,
+			"ClientIP":       policyClientIP,
+			"Username":       policyUsername,
+			"Header":         policyHeader,
+			"Claim":          policyClaim,
+			"PathSegment":    policyPathSegment,
+			"Hour":           policyHour,
+			"Weekday":        policyWeekday,
+			"InGroup":        policyInGroup,
+			"PathMatch":      policyPathMatch,
+			"RoutePathMatch": policyRoutePathMatch,
+			"BusinessHours":  policyBusinessHours,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	pr, ok := out.(policyPredicate)
+	if !ok {
+		return nil, fmt.Errorf("expected a boolean expression, got %T", out)
+	}
+	return pr, nil
+}
+
+func policyMethod() policyString {
+	return func(c *policyContext) string {
+		return c.req.Method
+	}
+}
+
+func policyPath() policyString {
+	return func(c *policyContext) string {
+		return c.req.URL.Path
+	}
+}
+
+func policyRoutePath() policyString {
+	return func(c *policyContext) string {
+		return Path(c.req)
+	}
+}
+
+func policyRouteName() policyString {
+	return func(c *policyContext) string {
+		return RouteName(c.req)
+	}
+}
+
+func policyClientIP() policyString {
+	return func(c *policyContext) string {
+		return ClientIP(c.req)
+	}
+}
+
+func policyUsername() policyString {
+	return func(c *policyContext) string {
+		return Username(c.req)
+	}
+}
+
+func policyHeader(name string) policyString {
+	return func(c *policyContext) string {
+		return c.req.Header.Get(name)
+	}
+}
+
+func policyClaim(name string) policyString {
+	return func(c *policyContext) string {
+		value, ok := Claim(c.req, name)
+		if !ok {
+			return ""
+		}
+		return claimToHeaderValue(value)
+	}
+}
+
+func policyPathSegment(index int) policyString {
+	return func(c *policyContext) string {
+		segments := strings.Split(strings.Trim(c.req.URL.Path, "/"), "/")
+		if index < 0 || index >= len(segments) {
+			return ""
+		}
+		return segments[index]
+	}
+}
+
+func policyHour() policyInt {
+	return func(c *policyContext) int {
+		return c.now.Hour()
+	}
+}
+
+func policyWeekday() policyInt {
+	return func(c *policyContext) int {
+		return int(c.now.Weekday())
+	}
+}
+
+func policyInGroup(pattern string) (policyPredicate, error) {
+	matchers, err := compileGroupMatchers([]string{pattern})
+	if err != nil {
+		return nil, err
+	}
+	return func(c *policyContext) bool {
+		return matchers.matchAny(groupsAndRoles(c.req))
+	}, nil
+}
+
+func policyPathMatch(pattern string) (policyPredicate, error) {
+	matcher, err := glob.Compile(pattern, '/')
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern '%s': %s", pattern, err.Error())
+	}
+	return func(c *policyContext) bool {
+		return matcher.Match(c.req.URL.Path)
+	}, nil
+}
+
+func policyRoutePathMatch(pattern string) (policyPredicate, error) {
+	matcher, err := glob.Compile(pattern, '/')
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern '%s': %s", pattern, err.Error())
+	}
+	return func(c *policyContext) bool {
+		return matcher.Match(Path(c.req))
+	}, nil
+}
+
+func policyBusinessHours(start, end int) policyPredicate {
+	return func(c *policyContext) bool {
+		weekday := c.now.Weekday()
+		if weekday == time.Saturday || weekday == time.Sunday {
+			return false
+		}
+		return c.now.Hour() >= start && c.now.Hour() < end
+	}
+}
+
+func policyAnd(fns ...policyPredicate) policyPredicate {
+	return func(c *policyContext) bool {
+		for _, fn := range fns {
+			if !fn(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func policyOr(fns ...policyPredicate) policyPredicate {
+	return func(c *policyContext) bool {
+		for _, fn := range fns {
+			if fn(c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func policyNot(fn policyPredicate) policyPredicate {
+	return func(c *policyContext) bool {
+		return !fn(c)
+	}
+}
+
+// policyEq Compare a string or an int to a constant or to another value of the same type
+func policyEq(left interface{}, right interface{}) (policyPredicate, error) {
+	switch l := left.(type) {
+	case policyString:
+		r, err := toPolicyString(right)
+		if err != nil {
+			return nil, err
+		}
+		return func(c *policyContext) bool {
+			return l(c) == r(c)
+		}, nil
+	case policyInt:
+		r, err := toPolicyInt(right)
+		if err != nil {
+			return nil, err
+		}
+		return func(c *policyContext) bool {
+			return l(c) == r(c)
+		}, nil
+	case string, int:
+		return policyEq(right, left)
+	}
+	return nil, fmt.Errorf("unsupported argument: %T", left)
+}
+
+func policyNeq(left interface{}, right interface{}) (policyPredicate, error) {
+	eq, err := policyEq(left, right)
+	if err != nil {
+		return nil, err
+	}
+	return policyNot(eq), nil
+}
+
+func policyCompare(left interface{}, right interface{}, cmp func(l, r int) bool) (policyPredicate, error) {
+	l, err := toPolicyInt(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toPolicyInt(right)
+	if err != nil {
+		return nil, err
+	}
+	return func(c *policyContext) bool {
+		return cmp(l(c), r(c))
+	}, nil
+}
+
+func policyLt(left interface{}, right interface{}) (policyPredicate, error) {
+	return policyCompare(left, right, func(l, r int) bool { return l < r })
+}
+
+func policyGt(left interface{}, right interface{}) (policyPredicate, error) {
+	return policyCompare(left, right, func(l, r int) bool { return l > r })
+}
+
+func policyLe(left interface{}, right interface{}) (policyPredicate, error) {
+	return policyCompare(left, right, func(l, r int) bool { return l <= r })
+}
+
+func policyGe(left interface{}, right interface{}) (policyPredicate, error) {
+	return policyCompare(left, right, func(l, r int) bool { return l >= r })
+}
+
+func toPolicyString(value interface{}) (policyString, error) {
+	switch v := value.(type) {
+	case policyString:
+		return v, nil
+	case string:
+		return func(c *policyContext) string {
+			return v
+		}, nil
+	}
+	return nil, fmt.Errorf("expected string, got %T", value)
+}
+
+func toPolicyInt(value interface{}) (policyInt, error) {
+	switch v := value.(type) {
+	case policyInt:
+		return v, nil
+	case int:
+		return func(c *policyContext) int {
+			return v
+		}, nil
+	}
+	return nil, fmt.Errorf("expected int, got %T", value)
+}
+
+// routePolicy Retrieve policy set on route, inline policy is preferred over policy file
+func routePolicy(proxyRoute ProxyRoute) (*Policy, error) {
+	if proxyRoute.Policy != nil {
+		return proxyRoute.Policy, nil
+	}
+	if proxyRoute.PolicyFile != "" {
+		return LoadPolicyFile(proxyRoute.PolicyFile)
+	}
+	return nil, nil
+}
+
+// policyHandler Evaluate policy of route and write decisions to audit logger
+func policyHandler(proxyRoute ProxyRoute, auditLogger *log.Logger, errorHandler ErrorHandler, next http.Handler) (http.Handler, error) {
+	policy, err := routePolicy(proxyRoute)
+	if err != nil {
+		return nil, fmt.Errorf("route '%s': %s", proxyRoute.Name, err.Error())
+	}
+	if policy == nil {
+		return next, nil
+	}
+	compiled, err := policy.compile()
+	if err != nil {
+		return nil, fmt.Errorf("route '%s': invalid policy: %s", proxyRoute.Name, err.Error())
+	}
+	// allowed requests are only logged at info level in a dedicated audit logger to not flood standard logs
+	allowedLevel := log.InfoLevel
+	if auditLogger == nil {
+		auditLogger = log.StandardLogger()
+		allowedLevel = log.DebugLevel
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		decision := compiled.evaluate(req)
+		entry := auditLogger.WithFields(log.Fields{
+			"audit":      "policy",
+			"decision":   string(decision.Effect),
+			"rule":       decision.Rule,
+			"route_name": proxyRoute.Name,
+			"user":       Username(req),
+			"groups":     Groups(req),
+			"roles":      Roles(req),
+			"method":     req.Method,
+			"path":       req.URL.Path,
+			"client_ip":  ClientIP(req),
+		})
+		if decision.Effect == PolicyDeny {
+			entry.Warn("orange-cloudfoundry/gobis/policy: request denied")
+			serveError(errorHandler, w, req, proxyRoute, http.StatusForbidden, ErrorKindDenied, fmt.Errorf("you are not allowed to access this resource"))
+			return
+		}
+		entry.Log(allowedLevel, "orange-cloudfoundry/gobis/policy: request allowed")
+		next.ServeHTTP(w, req)
+	}), nil
+}
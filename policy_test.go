@@ -0,0 +1,211 @@
+package gobis_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Policy", func() {
+	evaluate := func(policy Policy, req *http.Request) PolicyDecision {
+		decision, err := policy.Evaluate(req)
+		Expect(err).NotTo(HaveOccurred())
+		return decision
+	}
+	Context("Evaluate", func() {
+		It("should give effect of first rule matching", func() {
+			policy := Policy{
+				Rules: []PolicyRule{
+					{Name: "admin-write", Effect: PolicyDeny, When: `Method() == "POST" && PathMatch("/admin/**") && !InGroup("ops")`},
+					{Name: "admin", Effect: PolicyAllow, When: `PathMatch("/admin/**")`},
+				},
+			}
+			req := httptest.NewRequest("POST", "http://localhost/admin/users", nil)
+			Expect(evaluate(policy, req)).Should(Equal(PolicyDecision{Effect: PolicyDeny, Rule: "admin-write"}))
+
+			SetGroups(req, "ops")
+			Expect(evaluate(policy, req)).Should(Equal(PolicyDecision{Effect: PolicyAllow, Rule: "admin"}))
+		})
+		It("should apply default effect when no rule matches", func() {
+			req := httptest.NewRequest("GET", "http://localhost/app", nil)
+			Expect(evaluate(Policy{}, req)).Should(Equal(PolicyDecision{Effect: PolicyDeny}))
+			Expect(evaluate(Policy{Default: PolicyAllow}, req)).Should(Equal(PolicyDecision{Effect: PolicyAllow}))
+		})
+		It("should compare values given by functions", func() {
+			policy := Policy{
+				Rules: []PolicyRule{
+					{Name: "owner", Effect: PolicyAllow, When: `Method() == "GET" && Username() == PathSegment(1)`},
+					{Name: "header", Effect: PolicyAllow, When: `Header("X-Team") != "" && Claim("tenant") == Header("X-Team")`},
+					{Name: "time", Effect: PolicyDeny, When: `Hour() < 0 || Weekday() > 6`},
+				},
+			}
+			req := httptest.NewRequest("GET", "http://localhost/users/alice/profile", nil)
+			SetUsername(req, "alice")
+			Expect(evaluate(policy, req).Rule).Should(Equal("owner"))
+
+			SetUsername(req, "bob")
+			Expect(evaluate(policy, req).Rule).Should(BeEmpty())
+
+			SetClaim(req, "tenant", "blue")
+			req.Header.Set("X-Team", "blue")
+			Expect(evaluate(policy, req).Rule).Should(Equal("header"))
+		})
+		It("should refuse invalid policies", func() {
+			Expect(Policy{Default: "maybe"}.Check()).To(HaveOccurred())
+			Expect(Policy{Rules: []PolicyRule{{Effect: "maybe"}}}.Check()).To(HaveOccurred())
+			Expect(Policy{Rules: []PolicyRule{{Effect: PolicyAllow, When: `Unknown()`}}}.Check()).To(HaveOccurred())
+			Expect(Policy{Rules: []PolicyRule{{Effect: PolicyAllow, When: `Method()`}}}.Check()).To(HaveOccurred())
+			Expect(Policy{Rules: []PolicyRule{{Effect: PolicyAllow, When: `Hour() == "9"`}}}.Check()).To(HaveOccurred())
+			Expect(Policy{Timezone: "Nowhere/Unknown"}.Check()).To(HaveOccurred())
+		})
+	})
+	Context("LoadPolicyFile", func() {
+		var dir string
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "gobis-policy")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+		It("should load a yaml policy", func() {
+			policyFile := filepath.Join(dir, "policy.yml")
+			Expect(os.WriteFile(policyFile, []byte(`
+default: allow
+rules:
+- name: no-delete
+  effect: deny
+  when: Method() == "DELETE"
+`), 0600)).To(Succeed())
+			policy, err := LoadPolicyFile(policyFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy.Default).Should(Equal(PolicyAllow))
+			Expect(policy.Rules).Should(HaveLen(1))
+		})
+	})
+	Context("Handler", func() {
+		var handler http.Handler
+		var hook *test.Hook
+		BeforeEach(func() {
+			var err error
+			var auditLogger *log.Logger
+			auditLogger, hook = test.NewNullLogger()
+			factory := NewRouterFactory(&groupsMiddleware{}).(*RouterFactoryService)
+			factory.AuditLogger = auditLogger
+			handler, err = NewHandlerWithFactory([]ProxyRoute{
+				{
+					Name: "admin",
+					Path: NewPathMatcher("/admin/**"),
+					Policy: &Policy{
+						Rules: []PolicyRule{
+							{Name: "ops", Effect: PolicyAllow, When: `InGroup("ops") && RouteName() == "admin" && RoutePath() == "/users"`},
+						},
+					},
+					ForwardHandler:     http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+					OptionsPassthrough: true,
+				},
+			}, factory)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		serve := func(group string) int {
+			req := httptest.NewRequest("GET", "http://localhost/admin/users", nil)
+			req.Header.Set("X-Test-Group", group)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			return recorder.Code
+		}
+		It("should allow or deny requests and write decisions to audit log", func() {
+			Expect(serve("ops")).Should(Equal(http.StatusOK))
+			entry := hook.LastEntry()
+			Expect(entry.Level).Should(Equal(log.InfoLevel))
+			Expect(entry.Data["decision"]).Should(Equal("allow"))
+			Expect(entry.Data["rule"]).Should(Equal("ops"))
+			Expect(entry.Data["route_name"]).Should(Equal("admin"))
+
+			Expect(serve("dev")).Should(Equal(http.StatusForbidden))
+			entry = hook.LastEntry()
+			Expect(entry.Level).Should(Equal(log.WarnLevel))
+			Expect(entry.Data["decision"]).Should(Equal("deny"))
+			Expect(entry.Data["rule"]).Should(BeEmpty())
+			Expect(entry.Data["groups"]).Should(Equal([]string{"dev"}))
+		})
+		It("should not evaluate policy on passthrough requests", func() {
+			req := httptest.NewRequest("OPTIONS", "http://localhost/admin/users", nil)
+			req.Header.Set("Access-Control-Request-Method", "GET")
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			Expect(recorder.Code).Should(Equal(http.StatusOK))
+			Expect(hook.Entries).Should(BeEmpty())
+		})
+		It("should evaluate policy of parent route on sub-routes", func() {
+			factory := NewRouterFactory(&groupsMiddleware{}).(*RouterFactoryService)
+			handler, err := NewHandlerWithFactory([]ProxyRoute{
+				{
+					Name: "admin",
+					Path: NewPathMatcher("/admin/**"),
+					Url:  "http://my.upstream.com",
+					Policy: &Policy{
+						Rules: []PolicyRule{
+							{Name: "ops", Effect: PolicyAllow, When: `InGroup("ops")`},
+						},
+					},
+					Routes: []ProxyRoute{
+						{
+							Name:           "users",
+							Path:           NewPathMatcher("/users"),
+							Policy:         &Policy{Default: PolicyAllow},
+							ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+						},
+					},
+				},
+			}, factory)
+			Expect(err).NotTo(HaveOccurred())
+			serveSubRoute := func(group string) int {
+				req := httptest.NewRequest("GET", "http://localhost/admin/users", nil)
+				req.Header.Set("X-Test-Group", group)
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, req)
+				return recorder.Code
+			}
+			Expect(serveSubRoute("dev")).Should(Equal(http.StatusForbidden))
+			Expect(serveSubRoute("ops")).Should(Equal(http.StatusOK))
+		})
+		It("should log allowed requests at debug level in standard logger", func() {
+			hooks := log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			defer log.StandardLogger().ReplaceHooks(hooks)
+			level := log.GetLevel()
+			defer log.SetLevel(level)
+			log.SetLevel(log.DebugLevel)
+			globalHook := test.NewGlobal()
+			factory := NewRouterFactory(&groupsMiddleware{}).(*RouterFactoryService)
+			handler, err := NewHandlerWithFactory([]ProxyRoute{
+				{
+					Name:           "admin",
+					Path:           NewPathMatcher("/admin/**"),
+					Policy:         &Policy{Default: PolicyAllow},
+					ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				},
+			}, factory)
+			Expect(err).NotTo(HaveOccurred())
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost/admin/users", nil))
+			Expect(recorder.Code).Should(Equal(http.StatusOK))
+
+			var decisions []*log.Entry
+			for _, entry := range globalHook.AllEntries() {
+				if entry.Data["audit"] == "policy" {
+					decisions = append(decisions, entry)
+				}
+			}
+			Expect(decisions).Should(HaveLen(1))
+			Expect(decisions[0].Level).Should(Equal(log.DebugLevel))
+		})
+	})
+})
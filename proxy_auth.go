@@ -0,0 +1,68 @@
+package gobis
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ProxyAuth Credentials used to authenticate against an upstream proxy (http(s) or socks5)
+// Password can be loaded from a file (e.g.: a mounted secret) or an environment variable
+// which are read each time a connection to the proxy is made to follow secret rotation
+type ProxyAuth struct {
+	// Username User name to use
+	Username string `json:"username" yaml:"username"`
+	// Password Password to use (prefer PasswordFile or PasswordEnv to not store it in config)
+	Password string `json:"password" yaml:"password"`
+	// PasswordFile Path to a file containing password
+	PasswordFile string `json:"password_file" yaml:"password_file"`
+	// PasswordEnv Name of an environment variable containing password
+	PasswordEnv string `json:"password_env" yaml:"password_env"`
+}
+
+func (a ProxyAuth) Check() error {
+	if a.Username == "" {
+		return fmt.Errorf("username must be provided")
+	}
+	nbSources := 0
+	for _, source := range []string{a.Password, a.PasswordFile, a.PasswordEnv} {
+		if source != "" {
+			nbSources++
+		}
+	}
+	if nbSources > 1 {
+		return fmt.Errorf("only one of password, password_file or password_env can be set")
+	}
+	return nil
+}
+
+// UserInfo Load credentials to be used in a proxy url
+func (a ProxyAuth) UserInfo() (*url.Userinfo, error) {
+	password, err := a.loadPassword()
+	if err != nil {
+		return nil, err
+	}
+	if password == "" {
+		return url.User(a.Username), nil
+	}
+	return url.UserPassword(a.Username, password), nil
+}
+
+func (a ProxyAuth) loadPassword() (string, error) {
+	if a.PasswordFile != "" {
+		b, err := os.ReadFile(a.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read proxy password file: %s", err.Error())
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if a.PasswordEnv != "" {
+		password, ok := os.LookupEnv(a.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s for proxy password is not set", a.PasswordEnv)
+		}
+		return password, nil
+	}
+	return a.Password, nil
+}
@@ -0,0 +1,404 @@
+package gobis
+
+import (
+	"fmt"
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pacCacheTTL         = 5 * time.Minute
+	pacCacheMaxEntries  = 1000
+	pacFailedProxyDelay = 30 * time.Second
+)
+
+// pacUtils Standard functions available in PAC files, dnsResolve and myIpAddress are provided by gobis
+const pacUtils = `
+function dnsDomainIs(host, domain) {
+	return host.length >= domain.length && host.substring(host.length - domain.length) == domain;
+}
+function dnsDomainLevels(host) {
+	return host.split('.').length - 1;
+}
+function convert_addr(ipchars) {
+	var bytes = ipchars.split('.');
+	return ((bytes[0] & 0xff) << 24) | ((bytes[1] & 0xff) << 16) | ((bytes[2] & 0xff) << 8) | (bytes[3] & 0xff);
+}
+function isInNet(ipaddr, pattern, maskstr) {
+	if (!/^\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}$/.test(ipaddr)) {
+		ipaddr = dnsResolve(ipaddr);
+		if (ipaddr == null) {
+			return false;
+		}
+	}
+	var mask = convert_addr(maskstr);
+	return (convert_addr(ipaddr) & mask) == (convert_addr(pattern) & mask);
+}
+function isPlainHostName(host) {
+	return host.indexOf('.') == -1;
+}
+function isResolvable(host) {
+	return dnsResolve(host) != null;
+}
+function localHostOrDomainIs(host, hostdom) {
+	return host == hostdom || hostdom.lastIndexOf(host + '.', 0) == 0;
+}
+function shExpMatch(url, pattern) {
+	pattern = pattern.replace(/\./g, '\\.').replace(/\*/g, '.*').replace(/\?/g, '.');
+	return new RegExp('^' + pattern + '$').test(url);
+}
+var wdays = {SUN: 0, MON: 1, TUE: 2, WED: 3, THU: 4, FRI: 5, SAT: 6};
+var months = {JAN: 0, FEB: 1, MAR: 2, APR: 3, MAY: 4, JUN: 5, JUL: 6, AUG: 7, SEP: 8, OCT: 9, NOV: 10, DEC: 11};
+function weekdayRange() {
+	var args = Array.prototype.slice.call(arguments);
+	var gmt = args[args.length - 1] == 'GMT';
+	if (gmt) {
+		args.pop();
+	}
+	var now = new Date();
+	var wday = gmt ? now.getUTCDay() : now.getDay();
+	var wd1 = wdays[args[0]];
+	var wd2 = args.length == 2 ? wdays[args[1]] : wd1;
+	if (wd1 == undefined || wd2 == undefined) {
+		return false;
+	}
+	return wd1 <= wd2 ? (wd1 <= wday && wday <= wd2) : (wd2 >= wday || wday >= wd1);
+}
+function dateRange() {
+	var args = Array.prototype.slice.call(arguments);
+	var gmt = args[args.length - 1] == 'GMT';
+	if (gmt) {
+		args.pop();
+	}
+	var now = new Date();
+	var current = {
+		day: gmt ? now.getUTCDate() : now.getDate(),
+		month: gmt ? now.getUTCMonth() : now.getMonth(),
+		year: gmt ? now.getUTCFullYear() : now.getFullYear()
+	};
+	var toValue = function (arg) {
+		if (typeof arg == 'string') {
+			return {month: months[arg]};
+		}
+		if (arg > 31) {
+			return {year: arg};
+		}
+		return {day: arg};
+	};
+	var compare = function (value) {
+		if (value.year != undefined) {
+			return current.year - value.year;
+		}
+		if (value.month != undefined) {
+			return current.month - value.month;
+		}
+		return current.day - value.day;
+	};
+	if (args.length == 1) {
+		return compare(toValue(args[0])) == 0;
+	}
+	var half = args.length / 2;
+	var from = {}, to = {};
+	for (var i = 0; i < half; i++) {
+		var f = toValue(args[i]), t = toValue(args[half + i]);
+		for (var k in f) from[k] = f[k];
+		for (var k in t) to[k] = t[k];
+	}
+	var toNumber = function (value) {
+		return (value.year != undefined ? value.year : current.year) * 10000 +
+			(value.month != undefined ? value.month : current.month) * 100 +
+			(value.day != undefined ? value.day : current.day);
+	};
+	var nowNumber = current.year * 10000 + current.month * 100 + current.day;
+	return toNumber(from) <= nowNumber && nowNumber <= toNumber(to);
+}
+function timeRange() {
+	var args = Array.prototype.slice.call(arguments);
+	var gmt = args[args.length - 1] == 'GMT';
+	if (gmt) {
+		args.pop();
+	}
+	var now = new Date();
+	var current = (gmt ? now.getUTCHours() : now.getHours()) * 3600 +
+		(gmt ? now.getUTCMinutes() : now.getMinutes()) * 60 +
+		(gmt ? now.getUTCSeconds() : now.getSeconds());
+	if (args.length == 1) {
+		return Math.floor(current / 3600) == args[0];
+	}
+	var from, to;
+	if (args.length == 2) {
+		from = args[0] * 3600;
+		to = args[1] * 3600 + 3599;
+	} else if (args.length == 4) {
+		from = args[0] * 3600 + args[1] * 60;
+		to = args[2] * 3600 + args[3] * 60 + 59;
+	} else {
+		from = args[0] * 3600 + args[1] * 60 + args[2];
+		to = args[3] * 3600 + args[4] * 60 + args[5];
+	}
+	return from <= to ? (from <= current && current <= to) : (current >= from || current <= to);
+}
+`
+
+var pacUtilsProgram = goja.MustCompile("pac_utils.js", pacUtils, false)
+
+type pacCacheEntry struct {
+	proxies []*url.URL
+	expire  time.Time
+}
+
+// pacVM A javascript runtime where PAC file has been evaluated, a runtime can only be used by one goroutine at a time
+type pacVM struct {
+	vm        *goja.Runtime
+	findProxy goja.Callable
+}
+
+// pacResolver Evaluate a PAC file to find proxies to use for an url
+// A nil url in result means DIRECT
+// PAC file is compiled once and evaluated in a pool of runtimes to not serialize requests doing dns lookups
+type pacResolver struct {
+	program       *goja.Program
+	vms           sync.Pool
+	mu            sync.Mutex
+	cache         map[string]pacCacheEntry
+	failedProxies map[string]time.Time
+}
+
+// compilePac Load PAC content from pacFile, or use pacScript if set, and compile it
+func compilePac(pacFile, pacScript string) (*goja.Program, error) {
+	if pacFile != "" && pacScript != "" {
+		return nil, fmt.Errorf("only one of pac_file or pac_script can be set")
+	}
+	name := "pac_script"
+	if pacFile != "" {
+		b, err := os.ReadFile(pacFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read pac file: %s", err.Error())
+		}
+		name = pacFile
+		pacScript = string(b)
+	}
+	astProgram, err := goja.Parse(name, pacScript)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pac file: %s", err.Error())
+	}
+	if !declaresFindProxy(astProgram) {
+		return nil, fmt.Errorf("invalid pac file: function FindProxyForURL not found")
+	}
+	program, err := goja.CompileAST(astProgram, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pac file: %s", err.Error())
+	}
+	return program, nil
+}
+
+func declaresFindProxy(program *ast.Program) bool {
+	for _, statement := range program.Body {
+		declaration, ok := statement.(*ast.FunctionDeclaration)
+		if ok && declaration.Function.Name != nil && declaration.Function.Name.Name == "FindProxyForURL" {
+			return true
+		}
+	}
+	return false
+}
+
+func newPacResolver(pacFile, pacScript string) (*pacResolver, error) {
+	program, err := compilePac(pacFile, pacScript)
+	if err != nil {
+		return nil, err
+	}
+	resolver := &pacResolver{
+		program:       program,
+		cache:         make(map[string]pacCacheEntry),
+		failedProxies: make(map[string]time.Time),
+	}
+	// create a first runtime to report errors raised when evaluating pac file
+	vm, err := resolver.newVM()
+	if err != nil {
+		return nil, err
+	}
+	resolver.vms.Put(vm)
+	return resolver, nil
+}
+
+func (p *pacResolver) newVM() (*pacVM, error) {
+	vm := goja.New()
+	err := vm.Set("dnsResolve", func(host string) interface{} {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return nil
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				return ip.String()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = vm.Set("myIpAddress", myIpAddress)
+	if err != nil {
+		return nil, err
+	}
+	_, err = vm.RunProgram(pacUtilsProgram)
+	if err != nil {
+		return nil, err
+	}
+	_, err = vm.RunProgram(p.program)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pac file: %s", err.Error())
+	}
+	findProxy, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, fmt.Errorf("invalid pac file: function FindProxyForURL not found")
+	}
+	return &pacVM{vm: vm, findProxy: findProxy}, nil
+}
+
+// findProxyForURL Call FindProxyForURL in a runtime from the pool, no lock is held during evaluation
+func (p *pacResolver) findProxyForURL(pacUrl, host string) (string, error) {
+	vm, ok := p.vms.Get().(*pacVM)
+	if !ok {
+		var err error
+		vm, err = p.newVM()
+		if err != nil {
+			return "", err
+		}
+	}
+	defer p.vms.Put(vm)
+	result, err := vm.findProxy(goja.Undefined(), vm.vm.ToValue(pacUrl), vm.vm.ToValue(host))
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// FindProxy Return the first proxy which is not marked as failed for this url, nil is returned for DIRECT
+func (p *pacResolver) FindProxy(u *url.URL) (*url.URL, error) {
+	proxies, err := p.proxies(u)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, proxy := range proxies {
+		if proxy == nil {
+			return nil, nil
+		}
+		if failedAt, ok := p.failedProxies[proxy.Host]; ok && time.Since(failedAt) < pacFailedProxyDelay {
+			continue
+		}
+		proxyUrl := *proxy
+		return &proxyUrl, nil
+	}
+	if len(proxies) == 0 {
+		return nil, nil
+	}
+	// all proxies failed recently, retry the first one
+	if proxies[0] == nil {
+		return nil, nil
+	}
+	proxyUrl := *proxies[0]
+	return &proxyUrl, nil
+}
+
+// MarkFailed Mark a proxy address (host:port) as failed, next proxy in the list will be used for some time
+// Request which failed to reach proxy is not retried, only next requests use next proxy
+func (p *pacResolver) MarkFailed(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.cache {
+		for _, proxy := range entry.proxies {
+			if proxy != nil && proxy.Host == addr {
+				p.failedProxies[addr] = time.Now()
+				return
+			}
+		}
+	}
+}
+
+func (p *pacResolver) proxies(u *url.URL) ([]*url.URL, error) {
+	// like browsers do, path is never given to the pac file for security reasons
+	pacUrl := fmt.Sprintf("%s://%s/", u.Scheme, u.Host)
+	p.mu.Lock()
+	entry, ok := p.cache[pacUrl]
+	p.mu.Unlock()
+	if ok && time.Now().Before(entry.expire) {
+		return entry.proxies, nil
+	}
+	result, err := p.findProxyForURL(pacUrl, u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("error when evaluating pac file: %s", err.Error())
+	}
+	proxies, err := parsePacResult(result)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= pacCacheMaxEntries {
+		p.cache = make(map[string]pacCacheEntry)
+	}
+	p.cache[pacUrl] = pacCacheEntry{
+		proxies: proxies,
+		expire:  time.Now().Add(pacCacheTTL),
+	}
+	return proxies, nil
+}
+
+// parsePacResult Parse result of FindProxyForURL, e.g.: `PROXY proxy1:8080; SOCKS5 proxy2:1080; DIRECT`
+func parsePacResult(result string) ([]*url.URL, error) {
+	proxies := make([]*url.URL, 0)
+	for _, part := range strings.Split(result, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		kind := strings.ToUpper(fields[0])
+		if kind == "DIRECT" {
+			proxies = append(proxies, nil)
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid pac result %q", result)
+		}
+		var scheme string
+		switch kind {
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			log.Warnf("orange-cloudfoundry/gobis/pac: unsupported proxy type %s in pac result, it will be ignored", kind)
+			continue
+		}
+		proxies = append(proxies, &url.URL{Scheme: scheme, Host: fields[1]})
+	}
+	return proxies, nil
+}
+
+func myIpAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		return ipNet.IP.String()
+	}
+	return "127.0.0.1"
+}
@@ -0,0 +1,68 @@
+package gobis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	proxyProtocolV1Prefix = "PROXY "
+	// proxyProtocolV1MaxLength Maximum length of a PROXY protocol v1 header as defined by the spec (CRLF included)
+	proxyProtocolV1MaxLength = 107
+)
+
+// readProxyProtocolV1 Read a PROXY protocol v1 header and return the source address given inside
+// nil is returned as address when header is `PROXY UNKNOWN`
+func readProxyProtocolV1(reader *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, proxyProtocolV1MaxLength)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy protocol header: %s", err.Error())
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, fmt.Errorf("invalid proxy protocol header: header too long")
+		}
+	}
+	header := string(line)
+	if !strings.HasPrefix(header, proxyProtocolV1Prefix) || !strings.HasSuffix(header, "\r\n") {
+		return nil, fmt.Errorf("invalid proxy protocol header: %q", header)
+	}
+	parts := strings.Fields(strings.TrimSuffix(header, "\r\n"))
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid proxy protocol header: %q", header)
+	}
+	ip := net.ParseIP(parts[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid proxy protocol header: invalid source address %q", parts[2])
+	}
+	port, err := strconv.Atoi(parts[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid proxy protocol header: invalid source port %q", parts[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// proxyProtocolV1Header Create a PROXY protocol v1 header from a source and a destination address
+func proxyProtocolV1Header(src, dst net.Addr) string {
+	srcAddr, srcOk := src.(*net.TCPAddr)
+	dstAddr, dstOk := dst.(*net.TCPAddr)
+	if !srcOk || !dstOk {
+		return "PROXY UNKNOWN\r\n"
+	}
+	proto := "TCP4"
+	if srcAddr.IP.To4() == nil || dstAddr.IP.To4() == nil {
+		proto = "TCP6"
+	}
+	return fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcAddr.IP.String(), dstAddr.IP.String(), srcAddr.Port, dstAddr.Port)
+}
@@ -3,6 +3,7 @@ package gobis
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -30,14 +31,58 @@ type ProxyRoute struct {
 	ForwardedHeader string `json:"forwarded_header" yaml:"forwarded_header"`
 	// SensitiveHeaders List of headers which should not be sent to upstream
 	SensitiveHeaders []string `json:"sensitive_headers" yaml:"sensitive_headers"`
+	// AllowedGroups Only users having one of these groups (set by middlewares) can use this route, glob patterns are allowed
+	// Groups are checked after middlewares have been called (Default: groups are not checked)
+	// Sub-routes (see Routes) must pass allowed and denied groups of their parents as well as their own
+	// Requests forwarded by options_passthrough or hosts_passthrough skip middlewares and therefore groups checks
+	AllowedGroups []string `json:"allowed_groups" yaml:"allowed_groups"`
+	// DeniedGroups Users having one of these groups can't use this route, glob patterns are allowed
+	DeniedGroups []string `json:"denied_groups" yaml:"denied_groups"`
+	// Policy Rules evaluated after allowed_groups and denied_groups to allow or deny requests (see Policy)
+	// Sub-routes (see Routes) must be allowed by policy of their parents as well as their own
+	// Requests forwarded by options_passthrough or hosts_passthrough skip middlewares and therefore policies
+	Policy *Policy `json:"policy" yaml:"policy"`
+	// PolicyFile Path to a yaml or json file containing a policy, this is ignored if Policy is set
+	PolicyFile string `json:"policy_file" yaml:"policy_file"`
+	// ClientCert Usage of client certificate when handler has client_cert set: required, optional or ignored (Default: optional)
+	ClientCert ClientCertMode `json:"client_cert" yaml:"client_cert"`
+	// ProtectedHeaders List of headers which cannot be removed by `sensitive_headers`, they are added to those set on handler
+	ProtectedHeaders []string `json:"protected_headers" yaml:"protected_headers"`
+	// RequestHeaders Rules to add, set, append or remove headers on request sent to upstream
+	// Values are go templates, e.g.: {action: set, name: X-User, value: "{{ .Username }}"} (see HeaderTemplateData)
+	RequestHeaders HeaderRules `json:"request_headers" yaml:"request_headers"`
+	// ResponseHeaders Rules to add, set, append or remove headers on response received from upstream
+	ResponseHeaders HeaderRules `json:"response_headers" yaml:"response_headers"`
 	// Methods List of http methods allowed (Default: all methods are accepted)
 	Methods []string `json:"methods" yaml:"methods"`
 	// HttpProxy An url to a http proxy to make requests to upstream pass to this
+	// Socks5 proxies are also supported by using scheme socks5:// or socks5h://
 	HttpProxy string `json:"http_proxy" yaml:"http_proxy"`
 	// HttpsProxy An url to a https proxy to make requests to upstream pass to this
+	// Socks5 proxies are also supported by using scheme socks5:// or socks5h://
 	HttpsProxy string `json:"https_proxy" yaml:"https_proxy"`
+	// PACFile Path to a local PAC file to find proxy to use for upstream
+	// This is ignored if HttpProxy or HttpsProxy are set for the upstream scheme
+	// Proxy types PROXY, HTTPS, SOCKS and DIRECT are supported
+	// When a proxy can't be reached request fails and next requests use next proxy in list for 30 seconds
+	PACFile string `json:"pac_file" yaml:"pac_file"`
+	// PACScript Content of a PAC file, this can be used instead of PACFile
+	PACScript string `json:"pac_script" yaml:"pac_script"`
+	// ProxyAuth Credentials to use to authenticate against proxy (this override credentials given in proxy url)
+	// Credentials are also sent to proxy found from pac or environment variables when route doesn't set a proxy
+	ProxyAuth *ProxyAuth `json:"proxy_auth" yaml:"proxy_auth"`
 	// NoProxy Force to never use proxy even proxy from environment variables
 	NoProxy bool `json:"no_proxy" yaml:"no_proxy"`
+	// NoProxyHosts List of upstream hosts which should never be reached through a proxy, even proxy from environment variables
+	// Wildcard are allowed, e.g.: *.internal.my.domain.com
+	NoProxyHosts HostMatchers `json:"no_proxy_hosts" yaml:"no_proxy_hosts"`
+	// Resolve Force addresses to use when connecting to a host, host used in url is still used for TLS and Host header
+	// Key is a host:port (or only a host to match any port) and value a list of ip addresses (with or without port)
+	// e.g.: {"my.upstream.com:443": ["10.0.0.1", "10.0.0.2:8443"]}
+	// When a proxy is used the connection is made to the proxy which resolves upstream host itself, overrides are then not used
+	// This is why it can't be set with http_proxy, https_proxy, pac_file or pac_script, set no_proxy to not use proxy from environment
+	// Pac of handler is not used by routes setting resolve
+	Resolve map[string][]string `json:"resolve" yaml:"resolve"`
 	// NoBuffer Responses from upstream are buffered by default, it can be issue when sending big files
 	// Set to true to stream response
 	NoBuffer bool `json:"no_buffer" yaml:"no_buffer"`
@@ -45,25 +90,44 @@ type ProxyRoute struct {
 	RemoveProxyHeaders bool `json:"remove_proxy_headers" yaml:"remove_proxy_headers"`
 	// InsecureSkipVerify Set to true to not check ssl certificates from upstream (not really recommended)
 	InsecureSkipVerify bool `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	// Middlewares Names of middlewares to use on this route in the order they are called (Default: all middlewares given to handler in their order)
+	// Set an empty list to not use any middleware, names are matched case insensitively (see MiddlewareName)
+	// Order is changed when it doesn't respect dependencies declared by middlewares (see SortMiddlewares)
+	Middlewares []string `json:"middlewares" yaml:"middlewares"`
 	// MiddlewareParams It was made to pass arbitrary params to use it after in gobis middlewares
 	// This can be a structure (to set them programmatically) or a map[string]interface{} (to set them from a config file)
 	MiddlewareParams interface{} `json:"middleware_params" yaml:"middleware_params"`
-	// ShowError Set to true to see errors on web page when there is a panic error on gobis
+	// ParamsByMiddleware Params given only to one middleware, key is middleware name (see MiddlewareName) and value its params
+	// This avoids collisions between middlewares using same keys, these params take precedence over those in MiddlewareParams
+	// e.g.: {"cors": {"enabled": true}, "basic_auth": {"enabled": false}}
+	ParamsByMiddleware map[string]interface{} `json:"params_by_middleware" yaml:"params_by_middleware"`
+	// ShowError Set to true to see details of panic and upstream errors in error responses
 	ShowError bool `json:"show_error" yaml:"show_error"`
+	// ErrorFormat Format of error responses generated by gobis: json, problem (RFC 7807) or html (Default: error handler of factory)
+	ErrorFormat string `json:"error_format" yaml:"error_format"`
+	// ErrorTemplateFile Path to an html template used to render errors when error format is html (see ErrorData)
+	ErrorTemplateFile string `json:"error_template_file" yaml:"error_template_file"`
+	// ErrorHandler Set an error handler to render errors of this route when using gobis programmatically
+	ErrorHandler ErrorHandler `json:"-" yaml:"-"`
 	// UseFullPath Set to true to use full path
 	// e.g.: path=/metrics/** and request=/metrics/foo this will be redirected to /metrics/foo on upstream instead of /foo
 	UseFullPath bool `json:"use_full_path" yaml:"use_full_path"`
 	// Routes Chain others routes in a routes
+	// Middlewares of parent route are not called for sub-routes but its allowed and denied groups and its policy are checked
 	Routes []ProxyRoute `json:"routes" yaml:"routes"`
 	// ForwardHandler Set a handler to use to forward request to this handler when using gobis programmatically
 	ForwardHandler http.Handler `json:"-" yaml:"-"`
 	// OptionsPassthrough Will forward directly to proxied route OPTIONS method without using middlewares
+	// allowed_groups, denied_groups and policy are not checked for these requests
 	OptionsPassthrough bool `json:"options_passthrough" yaml:"options_passthrough"`
 	// HostsPassthrough Will forward directly to proxied route without using middlewares when http header host given match one of host in this list
+	// allowed_groups, denied_groups and policy are not checked for these requests
 	// Wildcard are allowed
 	// E.g.: - *.my.passthroughurl.com -> this will allow all routes matching this wildcard to passthrough middleware
 	// **Warning**: host header can be forged by user, this may be a security issue if not used properly.
 	HostsPassthrough HostMatchers `json:"hosts_passthrough" yaml:"hosts_passthrough"`
+	// parentChecks Parents of a sub-route with their access checks
+	parentChecks []ProxyRoute
 }
 
 func (r *ProxyRoute) UnmarshalJSON(data []byte) error {
@@ -95,14 +159,73 @@ func (r ProxyRoute) Check() error {
 		return fmt.Errorf("you must provide an URL or forwarded header to your routes")
 	}
 
-	_, err := url.Parse(r.HttpProxy)
+	_, err := parseProxyUrl(r.HttpProxy)
 	if err != nil && r.HttpProxy != "" {
 		return fmt.Errorf("invalid http_proxy : %s", err.Error())
 	}
-	_, err = url.Parse(r.HttpsProxy)
+	_, err = parseProxyUrl(r.HttpsProxy)
 	if err != nil && r.HttpsProxy != "" {
 		return fmt.Errorf("invalid https_proxy : %s", err.Error())
 	}
+	if r.PACFile != "" || r.PACScript != "" {
+		_, err = compilePac(r.PACFile, r.PACScript)
+		if err != nil {
+			return fmt.Errorf("invalid pac : %s", err.Error())
+		}
+	}
+	_, err = compileGroupMatchers(r.AllowedGroups)
+	if err != nil {
+		return fmt.Errorf("invalid allowed_groups : %s", err.Error())
+	}
+	_, err = compileGroupMatchers(r.DeniedGroups)
+	if err != nil {
+		return fmt.Errorf("invalid denied_groups : %s", err.Error())
+	}
+	if r.Policy != nil {
+		err = r.Policy.Check()
+		if err != nil {
+			return fmt.Errorf("invalid policy : %s", err.Error())
+		}
+	} else if r.PolicyFile != "" {
+		_, err = LoadPolicyFile(r.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("invalid policy_file : %s", err.Error())
+		}
+	}
+	if r.ErrorFormat != "" {
+		_, err = NewErrorHandler(r.ErrorFormat, r.ErrorTemplateFile)
+		if err != nil {
+			return fmt.Errorf("invalid error_format : %s", err.Error())
+		}
+	}
+	switch r.ClientCert {
+	case "", ClientCertOptional, ClientCertRequired, ClientCertIgnored:
+	default:
+		return fmt.Errorf("invalid client_cert : must be one of required, optional or ignored")
+	}
+	err = r.RequestHeaders.Check()
+	if err != nil {
+		return fmt.Errorf("invalid request_headers : %s", err.Error())
+	}
+	err = r.ResponseHeaders.Check()
+	if err != nil {
+		return fmt.Errorf("invalid response_headers : %s", err.Error())
+	}
+	if len(r.Resolve) > 0 && (r.HttpProxy != "" || r.HttpsProxy != "" || r.PACFile != "" || r.PACScript != "") {
+		return fmt.Errorf("invalid resolve : it can't be used with a proxy, upstream host is resolved by proxy")
+	}
+	for hostPort, addrs := range r.Resolve {
+		err = checkResolve(hostPort, addrs)
+		if err != nil {
+			return fmt.Errorf("invalid resolve : %s", err.Error())
+		}
+	}
+	if r.ProxyAuth != nil {
+		err = r.ProxyAuth.Check()
+		if err != nil {
+			return fmt.Errorf("invalid proxy_auth : %s", err.Error())
+		}
+	}
 	if r.Url == "" {
 		return nil
 	}
@@ -119,6 +242,25 @@ func (r ProxyRoute) Check() error {
 	return nil
 }
 
+func checkResolve(hostPort string, addrs []string) error {
+	if hostPort == "" {
+		return fmt.Errorf("host can't be empty")
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no address given for %s", hostPort)
+	}
+	for _, addr := range addrs {
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		if net.ParseIP(strings.Trim(host, "[]")) == nil {
+			return fmt.Errorf("address %s for %s is not an ip address", addr, hostPort)
+		}
+	}
+	return nil
+}
+
 func (r ProxyRoute) PathAsStartPath() string {
 	startPath := strings.TrimSuffix(r.Path.String(), "/**")
 	startPath = strings.TrimSuffix(startPath, "/*")
@@ -1,20 +1,71 @@
 package gobis
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
-var protectedHeaders = map[string]bool{}
+var (
+	protectedHeaders   = map[string]bool{}
+	protectedHeadersMu sync.RWMutex
+)
 
 type RouteTransport struct {
 	route         ProxyRoute
 	httpTransport *http.Transport
+	pacOnce       sync.Once
+	pac           *pacResolver
+	pacErr        error
+	dnsCache      *DnsCache
+	reqHeaders    compiledHeaderRules
+	respHeaders   compiledHeaderRules
+	headersErr    error
+	sendForwarded bool
+	// protectedHeaders Headers which can't be removed by sensitive headers, when nil package level protected headers are used
+	protectedHeaders map[string]bool
+}
+
+// RouteTransportOption Option to set handler level settings on a route transport
+type RouteTransportOption func(*RouteTransport)
+
+// WithDnsCache Make route transport use a dns cache shared with other routes to resolve upstream hosts
+func WithDnsCache(dnsCache *DnsCache) RouteTransportOption {
+	return func(r *RouteTransport) {
+		r.dnsCache = dnsCache
+	}
+}
+
+// WithProtectedHeaders Set list of headers which cannot be removed by sensitive headers for this transport
+// Protected headers set in route are added to them
+func WithProtectedHeaders(headers ...string) RouteTransportOption {
+	return func(r *RouteTransport) {
+		r.protectedHeaders = toProtectedHeaders(headers)
+	}
+}
+
+// withPacResolver Make route transport use a pac shared with other routes when it doesn't set its own pac nor resolve
+func withPacResolver(pac *pacResolver) RouteTransportOption {
+	return func(r *RouteTransport) {
+		if r.route.PACFile != "" || r.route.PACScript != "" || len(r.route.Resolve) > 0 {
+			return
+		}
+		r.pac = pac
+	}
+}
+
+// WithForwardedHeader Make route transport send standard Forwarded header (RFC 7239) to upstream
+func WithForwardedHeader() RouteTransportOption {
+	return func(r *RouteTransport) {
+		r.sendForwarded = true
+	}
 }
 
 const (
@@ -24,15 +75,19 @@ const (
 	XForwardedServer = "X-Forwarded-Server"
 )
 
-func NewRouteTransport(route ProxyRoute) http.RoundTripper {
-	return NewRouteTransportWithHttpTransport(route, NewDefaultTransport())
+func NewRouteTransport(route ProxyRoute, opts ...RouteTransportOption) http.RoundTripper {
+	return NewRouteTransportWithHttpTransport(route, NewDefaultTransport(), opts...)
 }
 
-func NewRouteTransportWithHttpTransport(route ProxyRoute, httpTransport *http.Transport) http.RoundTripper {
+func NewRouteTransportWithHttpTransport(route ProxyRoute, httpTransport *http.Transport, opts ...RouteTransportOption) http.RoundTripper {
 	routeTransport := &RouteTransport{
 		route:         route,
 		httpTransport: httpTransport,
 	}
+	for _, opt := range opts {
+		opt(routeTransport)
+	}
+	routeTransport.compileHeaderRules()
 	routeTransport.InitHttpTransport()
 	return routeTransport
 }
@@ -43,11 +98,59 @@ func (r *RouteTransport) InitHttpTransport() {
 		r.httpTransport.TLSClientConfig = &tls.Config{}
 	}
 	r.httpTransport.TLSClientConfig.InsecureSkipVerify = r.route.InsecureSkipVerify
+	if len(r.route.Resolve) == 0 && r.dnsCache == nil && !r.hasPAC() {
+		return
+	}
+	dial := r.httpTransport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	dial = r.resolveDialContext(dial)
+	if r.hasPAC() {
+		dial = r.pacFailoverDialContext(dial)
+	}
+	r.httpTransport.DialContext = dial
+}
+
+func (r *RouteTransport) compileHeaderRules() {
+	var err error
+	r.reqHeaders, err = r.route.RequestHeaders.compile()
+	if err != nil {
+		r.headersErr = fmt.Errorf("route '%s': invalid request_headers: %s", r.route.Name, err.Error())
+		return
+	}
+	r.respHeaders, err = r.route.ResponseHeaders.compile()
+	if err != nil {
+		r.headersErr = fmt.Errorf("route '%s': invalid response_headers: %s", r.route.Name, err.Error())
+	}
 }
 
 func (r *RouteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.headersErr != nil {
+		return nil, r.headersErr
+	}
 	r.TransformRequest(req)
-	return r.httpTransport.RoundTrip(req)
+	resp, err := r.httpTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	r.TransformResponse(resp, req)
+	return resp, nil
+}
+
+// TransformResponse Apply response header rules from route on upstream response
+func (r *RouteTransport) TransformResponse(resp *http.Response, req *http.Request) {
+	if len(r.respHeaders) == 0 {
+		return
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	err := r.respHeaders.apply(newHeaderTemplateData(r.route.Name, req, resp.Header))
+	if err != nil {
+		log.WithField("route_name", r.route.Name).
+			Errorf("orange-cloudfoundry/gobis/transport: error when applying response_headers: %s", err.Error())
+	}
 }
 
 func (r *RouteTransport) TransformRequest(req *http.Request) {
@@ -59,40 +162,183 @@ func (r *RouteTransport) TransformRequest(req *http.Request) {
 				XForwardedFor,
 				XForwardedHost,
 				XForwardedServer,
+				Forwarded,
 			}...)
+	} else if r.sendForwarded {
+		r.addForwardedElement(req)
 	}
 	for _, sensitiveHeader := range sensitiveHeaders {
 		sensitiveHeader = strings.TrimSpace(sensitiveHeader)
-		if _, ok := protectedHeaders[strings.ToLower(sensitiveHeader)]; ok {
+		if r.isProtectedHeader(sensitiveHeader) {
 			continue
 		}
 		req.Header.Del(sensitiveHeader)
 	}
+	if len(r.reqHeaders) == 0 {
+		return
+	}
+	err := r.reqHeaders.apply(newHeaderTemplateData(r.route.Name, req, req.Header))
+	if err != nil {
+		log.WithField("route_name", r.route.Name).
+			Errorf("orange-cloudfoundry/gobis/transport: error when applying request_headers: %s", err.Error())
+	}
+}
+
+// addForwardedElement Append an element describing this hop to Forwarded header
+func (r *RouteTransport) addForwardedElement(req *http.Request) {
+	element := forwardedElement(req)
+	if element == "" {
+		return
+	}
+	if prior := req.Header.Values(Forwarded); len(prior) > 0 {
+		element = strings.Join(prior, ", ") + ", " + element
+	}
+	req.Header.Set(Forwarded, element)
 }
 
 func (r *RouteTransport) ProxyFromRouteOrEnv(req *http.Request) (*url.URL, error) {
-	if r.route.NoProxy {
+	if r.route.NoProxy || r.route.NoProxyHosts.Match(req.URL.Hostname()) {
 		return nil, nil
 	}
-	if (req.URL.Scheme == "https" && r.route.HttpsProxy == "") ||
-		(req.URL.Scheme == "http" && r.route.HttpProxy == "") {
-		return http.ProxyFromEnvironment(req)
+	proxyURL, err := r.proxyFromRoute(req)
+	if err != nil || proxyURL == nil {
+		return proxyURL, err
+	}
+	if r.route.ProxyAuth == nil {
+		return proxyURL, nil
 	}
+	userInfo, err := r.route.ProxyAuth.UserInfo()
+	if err != nil {
+		return nil, fmt.Errorf("route '%s': %s", r.route.Name, err.Error())
+	}
+	// proxy url from environment is shared by the whole process, credentials must be set on a copy
+	routeProxyURL := *proxyURL
+	routeProxyURL.User = userInfo
+	return &routeProxyURL, nil
+}
+
+func (r *RouteTransport) proxyFromRoute(req *http.Request) (*url.URL, error) {
 	var proxy string
 	if req.URL.Scheme == "https" {
 		proxy = r.route.HttpsProxy
 	} else {
 		proxy = r.route.HttpProxy
 	}
-	proxyURL, err := url.Parse(proxy)
-	if err != nil || !strings.HasPrefix(proxyURL.Scheme, "http") {
-		if proxyURL, err := url.Parse("http://" + proxy); err == nil {
-			return proxyURL, nil
+	if proxy != "" {
+		return parseProxyUrl(proxy)
+	}
+	if r.hasPAC() {
+		return r.proxyFromPAC(req.URL)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+func (r *RouteTransport) hasPAC() bool {
+	return r.route.PACFile != "" || r.route.PACScript != "" || r.pac != nil
+}
+
+func (r *RouteTransport) proxyFromPAC(u *url.URL) (*url.URL, error) {
+	r.pacOnce.Do(func() {
+		if r.pac != nil {
+			// pac shared by handler
+			return
 		}
+		r.pac, r.pacErr = newPacResolver(r.route.PACFile, r.route.PACScript)
+	})
+	if r.pacErr != nil {
+		return nil, fmt.Errorf("route '%s': %s", r.route.Name, r.pacErr.Error())
 	}
+	return r.pac.FindProxy(u)
+}
+
+// resolveDialContext Dial addresses found in route Resolve option or in dns cache instead of letting dialer resolve host
+// Each address is tried until a connection succeed
+func (r *RouteTransport) resolveDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		addrs, err := r.resolve(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, resolvedAddr := range addrs {
+			conn, err := dial(ctx, network, resolvedAddr)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+func (r *RouteTransport) resolve(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}, nil
+	}
+	overrides, ok := r.route.Resolve[addr]
+	if !ok {
+		overrides, ok = r.route.Resolve[host]
+	}
+	if ok {
+		return joinHostsPort(overrides, port), nil
+	}
+	if r.dnsCache == nil || net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	ips, err := r.dnsCache.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("route '%s': failed to resolve %s: %w", r.route.Name, host, err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("route '%s': failed to resolve %s: no address found", r.route.Name, host)
+	}
+	return joinHostsPort(ips, port), nil
+}
+
+// joinHostsPort Add port to addresses which doesn't have one
+func joinHostsPort(addrs []string, port string) []string {
+	finalAddrs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err == nil {
+			finalAddrs[i] = addr
+			continue
+		}
+		finalAddrs[i] = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	return finalAddrs
+}
+
+// pacFailoverDialContext Mark proxies found in PAC file as failed when they can't be reached
+// to make next requests use the next proxy in the list, the current request is not retried and fails
+func (r *RouteTransport) pacFailoverDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil && r.pac != nil {
+			r.pac.MarkFailed(addr)
+		}
+		return conn, err
+	}
+}
+
+// parseProxyUrl Parse a proxy address, scheme http is used when no scheme is given
+// Accepted schemes are http, https, socks5 and socks5h
+func parseProxyUrl(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	proxyURL, err := url.Parse(proxy)
 	if err != nil {
 		return nil, fmt.Errorf("invalid proxy address %q: %v", proxy, err)
 	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy address %q: unsupported scheme %s", proxy, proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address %q: host is missing", proxy)
+	}
 	return proxyURL, nil
 }
 
@@ -109,9 +355,36 @@ func NewDefaultTransport() *http.Transport {
 	}
 }
 
+// SetProtectedHeaders Set package level list of headers which cannot be removed by sensitive headers
+// They are only used by route transports created without WithProtectedHeaders option
+//
+// Deprecated: use DefaultHandlerConfig.ProtectedHeaders or WithProtectedHeaders which are scoped to a handler
 func SetProtectedHeaders(protectHeaders []string) {
-	protectedHeaders = make(map[string]bool)
-	for _, protectHeader := range protectHeaders {
-		protectedHeaders[strings.ToLower(protectHeader)] = true
+	protected := toProtectedHeaders(protectHeaders)
+	protectedHeadersMu.Lock()
+	defer protectedHeadersMu.Unlock()
+	protectedHeaders = protected
+}
+
+func (r *RouteTransport) isProtectedHeader(header string) bool {
+	header = strings.ToLower(header)
+	for _, protectedHeader := range r.route.ProtectedHeaders {
+		if strings.ToLower(strings.TrimSpace(protectedHeader)) == header {
+			return true
+		}
+	}
+	if r.protectedHeaders != nil {
+		return r.protectedHeaders[header]
+	}
+	protectedHeadersMu.RLock()
+	defer protectedHeadersMu.RUnlock()
+	return protectedHeaders[header]
+}
+
+func toProtectedHeaders(headers []string) map[string]bool {
+	protected := make(map[string]bool)
+	for _, header := range headers {
+		protected[strings.ToLower(strings.TrimSpace(header))] = true
 	}
+	return protected
 }
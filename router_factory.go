@@ -4,14 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 	"github.com/thoas/go-funk"
 	"github.com/vulcand/oxy/buffer"
 	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/utils"
 	"net/http"
 	"net/url"
-	"reflect"
 	"runtime"
 	"strings"
 )
@@ -20,6 +19,7 @@ const (
 	GobisHeaderName = "X-Gobis-Forward"
 	XGobisUsername  = "X-Gobis-Username"
 	XGobisGroups    = "X-Gobis-Groups"
+	XGobisRoles     = "X-Gobis-Roles"
 )
 
 type JsonError struct {
@@ -39,9 +39,29 @@ type CreateTransportFunc func(ProxyRoute) http.RoundTripper
 
 type RouterFactoryService struct {
 	CreateTransportFunc CreateTransportFunc
-	MiddlewareHandlers  []MiddlewareHandler
-	muxRouterFunc       func() *mux.Router
-	middlewareChain     *MiddlewareChainRoutes
+	// MiddlewareHandlers Middlewares used by routes in this order, unless it doesn't respect dependencies they declare (see SortMiddlewares)
+	MiddlewareHandlers []MiddlewareHandler
+	// TransportOptions Options given to route transports created by default CreateTransportFunc
+	TransportOptions []RouteTransportOption
+	// IdentityHeaders Headers used to send username and groups to upstream
+	IdentityHeaders IdentityHeaders
+	// IdentityTokenSigner When set, a signed identity token is sent to upstream
+	IdentityTokenSigner *IdentityTokenSigner
+	// ClientCertMapper When set, identity is taken from verified client certificates according to route client_cert mode
+	ClientCertMapper *ClientCertMapper
+	// RoleMapping Expand user's groups into roles before checking allowed and denied groups of routes
+	RoleMapping RoleMapping
+	// AuditLogger Logger where policy decisions are written (Default: standard logger, allowed requests are then logged at debug level)
+	AuditLogger *log.Logger
+	// StrictParams Refuse params of routes which are not used by any of their middlewares
+	StrictParams bool
+	// ErrorHandler Render errors generated by gobis for routes which doesn't set their own (Default: JsonErrorHandler)
+	ErrorHandler ErrorHandler
+	// SharedResources Resources shared by all routes, given to middlewares implementing SharedResourcesUser
+	SharedResources *SharedResources
+	muxRouterFunc   func() *mux.Router
+	middlewareChain *MiddlewareChainRoutes
+	lifecycle       *lifecycle
 }
 type ErrMiddleware string
 
@@ -57,11 +77,13 @@ func NewRouterFactory(middlewareHandlers ...MiddlewareHandler) RouterFactory {
 
 func NewRouterFactoryWithMuxRouter(muxRouterOption func() *mux.Router, middlewares ...MiddlewareHandler) RouterFactory {
 	factory := &RouterFactoryService{
-		CreateTransportFunc: func(proxyRoute ProxyRoute) http.RoundTripper {
-			return NewRouteTransport(proxyRoute)
-		},
 		MiddlewareHandlers: middlewares,
+		SharedResources:    NewSharedResources(),
 		muxRouterFunc:      muxRouterOption,
+		lifecycle:          &lifecycle{},
+	}
+	factory.CreateTransportFunc = func(proxyRoute ProxyRoute) http.RoundTripper {
+		return NewRouteTransport(proxyRoute, factory.TransportOptions...)
 	}
 	factory.middlewareChain = NewMiddlewareChainRoutes(factory)
 	return factory
@@ -96,19 +118,55 @@ func (r RouterFactoryService) CreateMuxRouter(proxyRoutes []ProxyRoute, startPat
 }
 
 func (r RouterFactoryService) CreateReverseHandler(proxyRoute ProxyRoute) (http.Handler, error) {
+	if proxyRoute.ForwardHandler != nil {
+		return r.createReverseHandler(proxyRoute, nil, nil)
+	}
+	middlewares, params, err := r.decodeRouteMiddlewares(proxyRoute)
+	if err != nil {
+		return nil, err
+	}
+	return r.createReverseHandler(proxyRoute, middlewares, params)
+}
+
+// createReverseHandler Create reverse handler with middlewares of route and their params already decoded
+func (r RouterFactoryService) createReverseHandler(proxyRoute ProxyRoute, middlewares []MiddlewareHandler, params []interface{}) (http.Handler, error) {
 	entry := log.WithField("route_name", proxyRoute.Name)
 	if proxyRoute.ForwardHandler != nil {
 		entry.Debug("orange-cloudfoundry/gobis/proxy: Handler for routes will use forward handler provided.")
 		return proxyRoute.ForwardHandler, nil
 	}
-	var err error
+	modifyResponse := responseModifier(proxyRoute, middlewares, params)
+	errorHandler, err := r.routeErrorHandler(proxyRoute)
+	if err != nil {
+		return nil, err
+	}
+	upstreamErrorHandler := utils.ErrorHandlerFunc(func(w http.ResponseWriter, req *http.Request, err error) {
+		status := upstreamErrorStatus(err)
+		log.WithField("route_name", proxyRoute.Name).
+			Errorf("orange-cloudfoundry/gobis/proxy: error when calling upstream: %s", err.Error())
+		serveError(errorHandler, w, req, proxyRoute, status, ErrorKindUpstream, err)
+	})
+	transport := r.CreateTransportFunc(proxyRoute)
+	// header rules are compiled with transport, invalid rules must make handler creation fail
+	if routeTransport, ok := transport.(*RouteTransport); ok && routeTransport.headersErr != nil {
+		return nil, routeTransport.headersErr
+	}
 	var fwd *forward.Forwarder
 	if !proxyRoute.NoBuffer {
 		entry.Debug("orange-cloudfoundry/gobis/proxy: Handler for routes will use buffer.")
-		fwd, err = forward.New(forward.RoundTripper(r.CreateTransportFunc(proxyRoute)))
+		fwd, err = forward.New(
+			forward.RoundTripper(transport),
+			forward.ResponseModifier(modifyResponse),
+			forward.ErrorHandler(upstreamErrorHandler),
+		)
 	} else {
 		entry.Debug("orange-cloudfoundry/gobis/proxy: Handler for routes will use direct stream.")
-		fwd, err = forward.New(forward.RoundTripper(r.CreateTransportFunc(proxyRoute)), forward.Stream(true))
+		fwd, err = forward.New(
+			forward.RoundTripper(transport),
+			forward.ResponseModifier(modifyResponse),
+			forward.ErrorHandler(upstreamErrorHandler),
+			forward.Stream(true),
+		)
 	}
 	if err != nil {
 		return nil, err
@@ -119,6 +177,29 @@ func (r RouterFactoryService) CreateReverseHandler(proxyRoute ProxyRoute) (http.
 	return buffer.New(fwd, buffer.Retry(`IsNetworkError() && Attempts() < 2`))
 }
 
+// responseModifier Create a function calling response modifiers of route middlewares in reverse order
+func responseModifier(proxyRoute ProxyRoute, middlewares []MiddlewareHandler, params []interface{}) func(*http.Response) error {
+	modifiers := make([]ResponseModifier, 0)
+	modifiersParams := make([]interface{}, 0)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		modifier, ok := unwrapMiddleware(middlewares[i]).(ResponseModifier)
+		if !ok {
+			continue
+		}
+		modifiers = append(modifiers, modifier)
+		modifiersParams = append(modifiersParams, params[i])
+	}
+	return func(resp *http.Response) error {
+		for i, modifier := range modifiers {
+			err := modifier.ModifyResponse(proxyRoute, modifiersParams[i], resp.Request, resp)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func (r RouterFactoryService) routeMatch(proxyRoute ProxyRoute, startPath string) mux.MatcherFunc {
 	return func(req *http.Request, rm *mux.RouteMatch) bool {
 		if len(proxyRoute.Methods) > 0 && !funk.ContainsString(proxyRoute.Methods, req.Method) {
@@ -158,38 +239,68 @@ func (r RouterFactoryService) routeMatch(proxyRoute ProxyRoute, startPath string
 }
 
 func (r RouterFactoryService) CreateForwardHandler(proxyRoute ProxyRoute) (http.HandlerFunc, error) {
-	httpHandler, err := r.CreateReverseHandler(proxyRoute)
+	middlewares, params, err := r.decodeRouteMiddlewares(proxyRoute)
+	if err != nil {
+		return nil, err
+	}
+	httpHandler, err := r.createReverseHandler(proxyRoute, middlewares, params)
+	if err != nil {
+		return nil, err
+	}
+	errorHandler, err := r.routeErrorHandler(proxyRoute)
 	if err != nil {
 		return nil, err
 	}
 	forwardHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Del(GobisHeaderName)
 		restPath := Path(req)
-		ForwardRequest(proxyRoute, req, restPath)
-		httpHandler.ServeHTTP(w, req)
-	})
-	var handler http.Handler
-	handler = forwardHandler
-
-	for i := len(r.MiddlewareHandlers) - 1; i >= 0; i-- {
-		middleware := r.MiddlewareHandlers[i]
-		params := paramsToSchema(proxyRoute.MiddlewareParams, middleware.Schema())
-		handler, err = middlewareHandlerToHandler(middleware, proxyRoute, params, handler)
+		setRequestPath(req)
+		err := forwardRequest(proxyRoute, req, restPath, r.IdentityHeaders, r.IdentityTokenSigner)
 		if err != nil {
-			return nil, err
+			log.WithField("route_name", proxyRoute.Name).
+				Errorf("orange-cloudfoundry/gobis/proxy: error when signing identity token: %s", err.Error())
+			serveError(errorHandler, w, req, proxyRoute, http.StatusInternalServerError, ErrorKindInternal, err)
+			return
 		}
+		httpHandler.ServeHTTP(w, req)
+	})
+	checkedHandler, err := policyHandler(proxyRoute, r.AuditLogger, errorHandler, forwardHandler)
+	if err != nil {
+		return nil, err
+	}
+	authorizedHandler, err := authorizeHandler(proxyRoute, r.RoleMapping, errorHandler, checkedHandler)
+	if err != nil {
+		return nil, err
+	}
+	authorizedHandler, err = r.parentsCheckHandler(proxyRoute, errorHandler, authorizedHandler)
+	if err != nil {
+		return nil, err
+	}
+	handler, err := r.applyDecodedMiddlewares(proxyRoute, middlewares, params, authorizedHandler)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(proxyRoute.Routes) > 0 {
-		handler, err = middlewareHandlerToHandler(r.middlewareChain, proxyRoute, proxyRoute.Routes, handler)
+		handler, err = middlewareHandlerToHandler(r.middlewareChain, proxyRoute, subRoutes(proxyRoute), handler)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	return func(w http.ResponseWriter, req *http.Request) {
+		r.IdentityHeaders.stripInbound(req)
+		if r.IdentityTokenSigner != nil {
+			// a token sent by client must never reach upstream
+			req.Header.Del(r.IdentityTokenSigner.Header())
+		}
 		req.Header.Set(GobisHeaderName, "true")
 		w.Header().Set(GobisHeaderName, "true")
+		// client certificate is checked first, passthrough must not bypass a required certificate
+		if r.ClientCertMapper != nil && !r.ClientCertMapper.handle(proxyRoute.ClientCert, req) {
+			serveError(errorHandler, w, req, proxyRoute, http.StatusUnauthorized, ErrorKindDenied, fmt.Errorf("a valid client certificate is required"))
+			return
+		}
 		if proxyRoute.OptionsPassthrough &&
 			req.Method == "OPTIONS" &&
 			(req.Header.Get("Access-Control-Request-Method") != "" || req.Header.Get("Access-Control-Request-Headers") != "") {
@@ -201,14 +312,176 @@ func (r RouterFactoryService) CreateForwardHandler(proxyRoute ProxyRoute) (http.
 			return
 		}
 		setRouteName(req, proxyRoute.Name)
-		defer panicRecover(proxyRoute, w)
+		w = newDirtyResponseWriter(w, req)
+		defer panicRecover(proxyRoute, errorHandler, w, req)
 		handler.ServeHTTP(w, req)
 	}, nil
 }
 
+// parentsCheckHandler Check groups of user and policy of parents of a sub-route
+// Parent checks are done after middlewares of sub-route which set user's identity, first parent is checked first
+func (r RouterFactoryService) parentsCheckHandler(proxyRoute ProxyRoute, errorHandler ErrorHandler, next http.Handler) (http.Handler, error) {
+	handler := next
+	for i := len(proxyRoute.parentChecks) - 1; i >= 0; i-- {
+		parent := proxyRoute.parentChecks[i]
+		parentRoute := proxyRoute
+		parentRoute.AllowedGroups = parent.AllowedGroups
+		parentRoute.DeniedGroups = parent.DeniedGroups
+		parentRoute.Policy = parent.Policy
+		parentRoute.PolicyFile = parent.PolicyFile
+		// a policy set on handler is already the one of sub-route, don't evaluate it twice
+		if parent.Policy == proxyRoute.Policy && parent.PolicyFile == proxyRoute.PolicyFile {
+			parentRoute.Policy = nil
+			parentRoute.PolicyFile = ""
+		}
+		var err error
+		handler, err = policyHandler(parentRoute, r.AuditLogger, errorHandler, handler)
+		if err != nil {
+			return nil, fmt.Errorf("parent route '%s': %s", parent.Name, err.Error())
+		}
+		handler, err = authorizeHandler(parentRoute, r.RoleMapping, errorHandler, handler)
+		if err != nil {
+			return nil, fmt.Errorf("parent route '%s': %s", parent.Name, err.Error())
+		}
+	}
+	return handler, nil
+}
+
+// subRoutes Sub-routes of route which must pass access checks of route and its parents as well as their own
+func subRoutes(proxyRoute ProxyRoute) []ProxyRoute {
+	parentChecks := proxyRoute.parentChecks
+	if len(proxyRoute.AllowedGroups) > 0 || len(proxyRoute.DeniedGroups) > 0 ||
+		proxyRoute.Policy != nil || proxyRoute.PolicyFile != "" {
+		parentChecks = append(parentChecks[:len(parentChecks):len(parentChecks)], ProxyRoute{
+			Name:          proxyRoute.Name,
+			AllowedGroups: proxyRoute.AllowedGroups,
+			DeniedGroups:  proxyRoute.DeniedGroups,
+			Policy:        proxyRoute.Policy,
+			PolicyFile:    proxyRoute.PolicyFile,
+		})
+	}
+	routes := make([]ProxyRoute, len(proxyRoute.Routes))
+	for i, route := range proxyRoute.Routes {
+		route.parentChecks = parentChecks
+		routes[i] = route
+	}
+	return routes
+}
+
+// routeMiddlewares Retrieve middlewares listed by route in its order, all middlewares are used if route doesn't list them
+// Middlewares are then sorted according to their dependencies
+func (r RouterFactoryService) routeMiddlewares(proxyRoute ProxyRoute) ([]MiddlewareHandler, error) {
+	indexes, err := r.routeMiddlewareIndexes(proxyRoute)
+	if err != nil {
+		return nil, err
+	}
+	middlewares := make([]MiddlewareHandler, len(indexes))
+	for i, index := range indexes {
+		middlewares[i] = r.MiddlewareHandlers[index]
+	}
+	middlewares, err = SortMiddlewares(middlewares)
+	if err != nil {
+		return nil, ErrMiddleware(fmt.Sprintf("route '%s': %s", proxyRoute.Name, err.Error()))
+	}
+	return middlewares, nil
+}
+
+// routeMiddlewareIndexes Indexes in factory middlewares of middlewares used by route, in route order
+func (r RouterFactoryService) routeMiddlewareIndexes(proxyRoute ProxyRoute) ([]int, error) {
+	if proxyRoute.Middlewares == nil {
+		indexes := make([]int, len(r.MiddlewareHandlers))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+	indexes := make([]int, len(proxyRoute.Middlewares))
+	used := make(map[int]bool)
+	for i, name := range proxyRoute.Middlewares {
+		index := findMiddleware(r.MiddlewareHandlers, name)
+		if index < 0 {
+			return nil, ErrMiddleware(fmt.Sprintf("route '%s': unknown middleware '%s'", proxyRoute.Name, name))
+		}
+		if used[index] {
+			return nil, ErrMiddleware(fmt.Sprintf("route '%s': middleware '%s' is listed more than once", proxyRoute.Name, name))
+		}
+		used[index] = true
+		indexes[i] = index
+	}
+	return indexes, nil
+}
+
+// decodeRouteMiddlewares Find middlewares of route and decode their params
+func (r RouterFactoryService) decodeRouteMiddlewares(proxyRoute ProxyRoute) ([]MiddlewareHandler, []interface{}, error) {
+	middlewares, err := r.routeMiddlewares(proxyRoute)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.warnUnknownParams(proxyRoute)
+	params, err := r.decodeMiddlewaresParams(proxyRoute, middlewares)
+	if err != nil {
+		return nil, nil, err
+	}
+	return middlewares, params, nil
+}
+
+// applyMiddlewares Wrap handler with middlewares of route, first middleware in the list will be the first called
+// Only middlewares used by route are started
+func (r RouterFactoryService) applyMiddlewares(proxyRoute ProxyRoute, handler http.Handler) (http.Handler, error) {
+	middlewares, params, err := r.decodeRouteMiddlewares(proxyRoute)
+	if err != nil {
+		return nil, err
+	}
+	return r.applyDecodedMiddlewares(proxyRoute, middlewares, params, handler)
+}
+
+// applyDecodedMiddlewares Wrap handler with middlewares of route and their params already decoded
+func (r RouterFactoryService) applyDecodedMiddlewares(proxyRoute ProxyRoute, middlewares []MiddlewareHandler, params []interface{}, handler http.Handler) (http.Handler, error) {
+	if r.lifecycle != nil {
+		indexes, err := r.routeMiddlewareIndexes(proxyRoute)
+		if err != nil {
+			return nil, err
+		}
+		err = r.lifecycle.startMiddlewares(r.MiddlewareHandlers, indexes, r.SharedResources)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler, err = middlewareHandlerToHandler(middlewares[i], proxyRoute, params[i], handler)
+		if err != nil {
+			return nil, err
+		}
+		if r.lifecycle == nil {
+			continue
+		}
+		err = r.lifecycle.track(handler)
+		if err != nil {
+			return nil, ErrMiddleware(fmt.Sprintf("Failed to start handler of middleware %s: %s", MiddlewareName(middlewares[i]), err.Error()))
+		}
+	}
+	return handler, nil
+}
+
+// Close Close handlers created by middlewares, middlewares and shared resources implementing Closer
+// Handlers created by this factory must not be used after
+func (r RouterFactoryService) Close() error {
+	var firstErr error
+	if r.lifecycle != nil {
+		firstErr = r.lifecycle.close()
+	}
+	if r.SharedResources != nil {
+		if err := r.SharedResources.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func middlewareHandlerToHandler(middleware MiddlewareHandler, proxyRoute ProxyRoute, params interface{}, next http.Handler) (http.Handler, error) {
 	entry := log.WithField("route_name", proxyRoute.Name)
-	funcName := GetMiddlewareName(middleware)
+	funcName := MiddlewareName(middleware)
 	entry.Debugf("orange-cloudfoundry/gobis/proxy: Adding %s middleware ...", funcName)
 	handler, err := middleware.Handler(proxyRoute, params, next)
 	if err != nil {
@@ -218,22 +491,26 @@ func middlewareHandlerToHandler(middleware MiddlewareHandler, proxyRoute ProxyRo
 	return handler, nil
 }
 
-func paramsToSchema(params interface{}, schema interface{}) interface{} {
-	if params != nil && reflect.TypeOf(params).Kind() != reflect.Map {
-		params = InterfaceToMap(params)
-	}
-	val := reflect.New(reflect.TypeOf(schema))
-	err := mapstructure.Decode(params, val.Interface())
-	if err != nil {
-		panic(err)
-	}
-	return val.Elem().Interface()
+// ForwardRequest Rewrite request to be sent to upstream, default identity headers are used
+func ForwardRequest(proxyRoute ProxyRoute, req *http.Request, restPath string) {
+	//nolint:errcheck
+	forwardRequest(proxyRoute, req, restPath, IdentityHeaders{}, nil)
 }
 
-func ForwardRequest(proxyRoute ProxyRoute, req *http.Request, restPath string) {
+// forwardRequest Rewrite request to be sent to upstream, an error is returned when identity token can't be signed
+// Request must not be forwarded in this case
+func forwardRequest(proxyRoute ProxyRoute, req *http.Request, restPath string, identityHeaders IdentityHeaders, tokenSigner *IdentityTokenSigner) error {
 	removeDirtyHeaders(req)
-	req.Header.Add(XGobisUsername, Username(req))
-	req.Header.Add(XGobisGroups, strings.Join(Groups(req), ","))
+	identityHeaders.set(req)
+	if tokenSigner != nil {
+		if tokenSigner.config.OnlyToken {
+			identityHeaders.remove(req)
+		}
+		err := tokenSigner.setIdentityToken(req, proxyRoute.Name)
+		if err != nil {
+			return err
+		}
+	}
 	fwdUrl := proxyRoute.UpstreamUrl(req)
 	req.URL.Host = fwdUrl.Host
 	req.URL.Scheme = fwdUrl.Scheme
@@ -253,42 +530,19 @@ func ForwardRequest(proxyRoute ProxyRoute, req *http.Request, restPath string) {
 		req.SetBasicAuth(fwdUrl.User.Username(), password)
 	}
 	req.RequestURI = req.URL.RequestURI()
+	return nil
 }
 
 func removeDirtyHeaders(req *http.Request) {
-	dirtyHeadersPtr := DirtyHeaders(req)
-	if dirtyHeadersPtr == nil {
-		return
-	}
-	dirtyHeaders := *dirtyHeadersPtr
-	for header, oldValue := range dirtyHeaders {
-		if oldValue == "" {
-			req.Header.Del(header)
-			continue
-		}
-		req.Header.Set(header, oldValue)
-	}
+	cleanDirtyHeaders(req.Header, DirtyHeadersValues(req))
 }
 
-func panicRecover(proxyRoute ProxyRoute, w http.ResponseWriter) {
+func panicRecover(proxyRoute ProxyRoute, errorHandler ErrorHandler, w http.ResponseWriter, req *http.Request) {
 	err := recover()
 	if err == nil {
 		return
 	}
-	w.WriteHeader(http.StatusInternalServerError)
-	if proxyRoute.ShowError {
-		w.Header().Set("Content-Type", "application/json")
-		errMsg := JsonError{
-			Status:    http.StatusInternalServerError,
-			Title:     http.StatusText(http.StatusInternalServerError),
-			Details:   fmt.Sprint(err),
-			RouteName: proxyRoute.Name,
-		}
-		b, _ := json.MarshalIndent(errMsg, "", "\t")
-		if _, err := w.Write(b); err != nil {
-			log.Errorf("write failed: %s", err.Error())
-		}
-	}
+	serveError(errorHandler, w, req, proxyRoute, http.StatusInternalServerError, ErrorKindPanic, fmt.Errorf("%v", err))
 	entry := log.WithField("route_name", proxyRoute.Name)
 	identName, identFile := identifyPanic()
 	if identName != "" {
@@ -298,6 +552,21 @@ func panicRecover(proxyRoute ProxyRoute, w http.ResponseWriter) {
 	entry.Error(err)
 }
 
+func writeJsonError(w http.ResponseWriter, status int, routeName string, details string) {
+	errMsg := JsonError{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Details:   details,
+		RouteName: routeName,
+	}
+	b, _ := json.MarshalIndent(errMsg, "", "\t")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		log.Errorf("write failed: %s", err.Error())
+	}
+}
+
 func identifyPanic() (string, string) {
 	var name, file string
 	var line int
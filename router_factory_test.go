@@ -6,6 +6,7 @@ import (
 	. "github.com/onsi/gomega"
 	. "github.com/orange-cloudfoundry/gobis"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 )
 
@@ -93,6 +94,51 @@ var _ = Describe("RouterFactory", func() {
 			})
 		})
 	})
+	Context("CreateForwardHandler", func() {
+		var receivedHeaders http.Header
+		route := ProxyRoute{
+			Name: "app",
+			Path: NewPathMatcher("/**"),
+			ForwardHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				receivedHeaders = r.Header.Clone()
+			}),
+		}
+		It("should remove identity headers sent by client", func() {
+			req := httptest.NewRequest("GET", "http://localhost/path", nil)
+			req.Header.Set(XGobisUsername, "admin")
+			req.Header.Set(XGobisGroups, "admin")
+			req.Header.Set("X-Gobis-Other", "value")
+			handler, err := factory.CreateForwardHandler(route)
+			Expect(err).NotTo(HaveOccurred())
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			Expect(receivedHeaders.Values(XGobisUsername)).Should(Equal([]string{""}))
+			Expect(receivedHeaders.Values(XGobisGroups)).Should(Equal([]string{""}))
+			Expect(receivedHeaders.Get("X-Gobis-Other")).Should(BeEmpty())
+		})
+		It("should use configured identity headers and skip anonymous requests", func() {
+			factory := NewRouterFactory().(*RouterFactoryService)
+			factory.IdentityHeaders = IdentityHeaders{
+				UsernameHeader: "X-User",
+				GroupsHeader:   "X-Groups",
+				SkipAnonymous:  true,
+			}
+			req := httptest.NewRequest("GET", "http://localhost/path", nil)
+			req.Header.Set("X-User", "admin")
+			req.Header.Set(XGobisUsername, "admin")
+			handler, err := factory.CreateForwardHandler(route)
+			Expect(err).NotTo(HaveOccurred())
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			Expect(receivedHeaders).ShouldNot(HaveKey("X-User"))
+			Expect(receivedHeaders).ShouldNot(HaveKey("X-Groups"))
+			Expect(receivedHeaders).ShouldNot(HaveKey(XGobisUsername))
+
+			req = httptest.NewRequest("GET", "http://localhost/path", nil)
+			SetUsername(req, "myuser")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			Expect(receivedHeaders.Get("X-User")).Should(Equal("myuser"))
+			Expect(receivedHeaders.Values("X-Groups")).Should(Equal([]string{""}))
+		})
+	})
 	Context("CreateMuxRouter", func() {
 		Context("when route have option ForwardedHeader set", func() {
 			It("should copy get parameter in the request from upstream", func() {
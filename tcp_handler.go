@@ -0,0 +1,437 @@
+package gobis
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tlsRecordTypeHandshake = 0x16
+	defaultTcpDialTimeout  = 30 * time.Second
+	clientHelloTimeout     = 10 * time.Second
+	maxTlsRecordLength     = 16384 + 2048
+)
+
+type TcpHandlerConfig struct {
+	// List of tcp routes, first route matching a connection is used
+	Routes []TcpRoute `json:"routes" yaml:"routes"`
+	// AcceptProxyProtocol Set to true if connections received start with a PROXY protocol (v1) header
+	// (e.g.: when gobis is behind a load balancer sending it)
+	AcceptProxyProtocol bool `json:"accept_proxy_protocol" yaml:"accept_proxy_protocol"`
+	// IdleTimeout Close connection when no data has been exchanged during this time (default: no timeout)
+	IdleTimeout Duration `json:"idle_timeout" yaml:"idle_timeout"`
+}
+
+// TcpRouteStats Counters for a tcp route
+type TcpRouteStats struct {
+	// BytesReceived Number of bytes received from clients and sent to upstream
+	BytesReceived int64 `json:"bytes_received"`
+	// BytesSent Number of bytes received from upstream and sent to clients
+	BytesSent int64 `json:"bytes_sent"`
+	// TotalConnections Number of connections handled since start
+	TotalConnections int64 `json:"total_connections"`
+	// ActiveConnections Number of connections currently open
+	ActiveConnections int64 `json:"active_connections"`
+}
+
+type tcpRouteCounters struct {
+	bytesReceived     atomic.Int64
+	bytesSent         atomic.Int64
+	totalConnections  atomic.Int64
+	activeConnections atomic.Int64
+}
+
+// TcpHandler A layer-4 proxy which forward raw tcp connections to upstreams
+// TLS connections are never terminated, they are routed by server name (SNI) found in client hello
+type TcpHandler struct {
+	config    TcpHandlerConfig
+	counters  map[string]*tcpRouteCounters
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewTcpHandler(config TcpHandlerConfig) (*TcpHandler, error) {
+	counters := make(map[string]*tcpRouteCounters)
+	for _, route := range config.Routes {
+		if err := route.Check(); err != nil {
+			return nil, fmt.Errorf("tcp route '%s': %s", route.Name, err.Error())
+		}
+		if _, ok := counters[route.Name]; ok {
+			return nil, fmt.Errorf("tcp route '%s' is defined multiple times", route.Name)
+		}
+		counters[route.Name] = &tcpRouteCounters{}
+	}
+	return &TcpHandler{
+		config:    config,
+		counters:  counters,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// ListenAndServe Listen on tcp address given and serve connections
+func (h *TcpHandler) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return h.Serve(ln)
+}
+
+// Serve Accept connections on listener and route them, this can be called for multiple listeners
+func (h *TcpHandler) Serve(ln net.Listener) error {
+	if !h.trackListener(ln, true) {
+		return net.ErrClosed
+	}
+	defer h.trackListener(ln, false)
+	log.Debugf("orange-cloudfoundry/gobis/tcp: Serving connections on %s ...", ln.Addr().String())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if h.isClosed() {
+				return net.ErrClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go h.ServeConn(conn)
+	}
+}
+
+// ServeConn Route a single connection to its upstream, it blocks until connection is closed
+func (h *TcpHandler) ServeConn(conn net.Conn) {
+	if !h.trackConn(conn, true) {
+		//nolint:errcheck
+		conn.Close()
+		return
+	}
+	defer h.trackConn(conn, false)
+	//nolint:errcheck
+	defer conn.Close()
+
+	port := localPort(conn)
+	needServerName := h.needServerName(port)
+	var reader *bufio.Reader
+	if needServerName {
+		reader = bufio.NewReaderSize(conn, maxTlsRecordLength+5)
+	} else {
+		reader = bufio.NewReader(conn)
+	}
+	clientAddr := conn.RemoteAddr()
+	if h.config.AcceptProxyProtocol {
+		addr, err := readProxyProtocolV1(reader)
+		if err != nil {
+			log.Warnf("orange-cloudfoundry/gobis/tcp: connection from %s refused: %s", clientAddr.String(), err.Error())
+			return
+		}
+		if addr != nil {
+			clientAddr = addr
+		}
+	}
+
+	serverName := ""
+	if needServerName {
+		var err error
+		serverName, err = peekServerName(conn, reader)
+		if err != nil {
+			log.Debugf("orange-cloudfoundry/gobis/tcp: no server name found for connection from %s: %s", clientAddr.String(), err.Error())
+		}
+	}
+
+	route, ok := h.findRoute(port, serverName)
+	if !ok {
+		log.Warnf("orange-cloudfoundry/gobis/tcp: no route found for connection from %s (server name: '%s')", clientAddr.String(), serverName)
+		return
+	}
+	entry := log.WithField("route_name", route.Name)
+	counters := h.counters[route.Name]
+	counters.totalConnections.Add(1)
+	counters.activeConnections.Add(1)
+	defer counters.activeConnections.Add(-1)
+
+	dialTimeout := route.DialTimeout.Duration()
+	if dialTimeout == 0 {
+		dialTimeout = defaultTcpDialTimeout
+	}
+	upstream, err := net.DialTimeout("tcp", route.Upstream, dialTimeout)
+	if err != nil {
+		entry.Errorf("orange-cloudfoundry/gobis/tcp: error when connecting to upstream %s: %s", route.Upstream, err.Error())
+		return
+	}
+	if !h.trackConn(upstream, true) {
+		//nolint:errcheck
+		upstream.Close()
+		return
+	}
+	defer h.trackConn(upstream, false)
+	//nolint:errcheck
+	defer upstream.Close()
+
+	if route.SendProxyProtocol {
+		_, err := io.WriteString(upstream, proxyProtocolV1Header(clientAddr, conn.LocalAddr()))
+		if err != nil {
+			entry.Errorf("orange-cloudfoundry/gobis/tcp: error when sending proxy protocol header to upstream: %s", err.Error())
+			return
+		}
+	}
+
+	idleTimeout := route.IdleTimeout.Duration()
+	if idleTimeout == 0 {
+		idleTimeout = h.config.IdleTimeout.Duration()
+	}
+	entry.Debugf("orange-cloudfoundry/gobis/tcp: forwarding connection from %s to %s", clientAddr.String(), route.Upstream)
+	pipeConns(
+		&idleConn{Conn: conn, reader: reader, timeout: idleTimeout},
+		&idleConn{Conn: upstream, reader: upstream, timeout: idleTimeout},
+		&counters.bytesReceived,
+		&counters.bytesSent,
+	)
+	entry.Debugf("orange-cloudfoundry/gobis/tcp: connection from %s closed", clientAddr.String())
+}
+
+// Stats Retrieve counters for a tcp route
+func (h *TcpHandler) Stats(routeName string) (TcpRouteStats, bool) {
+	counters, ok := h.counters[routeName]
+	if !ok {
+		return TcpRouteStats{}, false
+	}
+	return TcpRouteStats{
+		BytesReceived:     counters.bytesReceived.Load(),
+		BytesSent:         counters.bytesSent.Load(),
+		TotalConnections:  counters.totalConnections.Load(),
+		ActiveConnections: counters.activeConnections.Load(),
+	}, true
+}
+
+// AllStats Retrieve counters for all tcp routes by route name
+func (h *TcpHandler) AllStats() map[string]TcpRouteStats {
+	allStats := make(map[string]TcpRouteStats)
+	for name := range h.counters {
+		allStats[name], _ = h.Stats(name)
+	}
+	return allStats
+}
+
+// Close Stop all listeners and close all opened connections
+func (h *TcpHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	var firstErr error
+	for ln := range h.listeners {
+		if err := ln.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for conn := range h.conns {
+		//nolint:errcheck
+		conn.Close()
+	}
+	return firstErr
+}
+
+func (h *TcpHandler) findRoute(port int, serverName string) (TcpRoute, bool) {
+	for _, route := range h.config.Routes {
+		if route.match(port, serverName) {
+			return route, true
+		}
+	}
+	return TcpRoute{}, false
+}
+
+// needServerName Client hello must be read only if a route matching on sni can be chosen for this port,
+// otherwise protocols where server speaks first would wait for client hello timeout
+func (h *TcpHandler) needServerName(port int) bool {
+	for _, route := range h.config.Routes {
+		if route.ListenPort != 0 && route.ListenPort != port {
+			continue
+		}
+		// a route without sni hosts match every connection on this port, next routes can't be chosen
+		return len(route.SniHosts) > 0
+	}
+	return false
+}
+
+func (h *TcpHandler) isClosed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+func (h *TcpHandler) trackListener(ln net.Listener, add bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !add {
+		delete(h.listeners, ln)
+		return true
+	}
+	if h.closed {
+		return false
+	}
+	h.listeners[ln] = struct{}{}
+	return true
+}
+
+func (h *TcpHandler) trackConn(conn net.Conn, add bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !add {
+		delete(h.conns, conn)
+		return true
+	}
+	if h.closed {
+		return false
+	}
+	h.conns[conn] = struct{}{}
+	return true
+}
+
+func localPort(conn net.Conn) int {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// peekServerName Read server name from a TLS client hello without consuming data from the reader
+func peekServerName(conn net.Conn, reader *bufio.Reader) (string, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(clientHelloTimeout)); err != nil {
+		return "", err
+	}
+	//nolint:errcheck
+	defer conn.SetReadDeadline(time.Time{})
+	firstByte, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if firstByte[0] != tlsRecordTypeHandshake {
+		return "", fmt.Errorf("not a tls connection")
+	}
+	// record header is 5 bytes long and contains record length in its last 2 bytes
+	header, err := reader.Peek(5)
+	if err != nil {
+		return "", err
+	}
+	recordLength := int(header[3])<<8 | int(header[4])
+	record, err := reader.Peek(5 + recordLength)
+	if err != nil {
+		return "", err
+	}
+	return readServerName(record)
+}
+
+// readServerName Let crypto/tls parse the client hello and retrieve server name from it
+func readServerName(record []byte) (string, error) {
+	var serverName string
+	found := false
+	err := tls.Server(&readOnlyConn{reader: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			found = true
+			return nil, fmt.Errorf("client hello read")
+		},
+	}).Handshake()
+	if !found {
+		return "", err
+	}
+	return serverName, nil
+}
+
+type readOnlyConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *readOnlyConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c *readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c *readOnlyConn) Close() error                       { return nil }
+func (c *readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c *readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c *readOnlyConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *readOnlyConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *readOnlyConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+// idleConn A connection which is closed when no data is read or written during timeout
+type idleConn struct {
+	net.Conn
+	reader  io.Reader
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.reader.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *idleConn) closeWrite() {
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		//nolint:errcheck
+		tcpConn.CloseWrite()
+		return
+	}
+	//nolint:errcheck
+	c.Conn.Close()
+}
+
+// pipeConns Copy data between client and upstream in both directions until one side close
+func pipeConns(client, upstream *idleConn, bytesReceived, bytesSent *atomic.Int64) {
+	var wg sync.WaitGroup
+	copyConn := func(dst, src *idleConn, counter *atomic.Int64) {
+		defer wg.Done()
+		buf := make([]byte, 32*1024)
+		for {
+			nr, readErr := src.Read(buf)
+			if nr > 0 {
+				nw, writeErr := dst.Write(buf[:nr])
+				counter.Add(int64(nw))
+				if writeErr != nil {
+					readErr = writeErr
+				}
+			}
+			if readErr == io.EOF {
+				dst.closeWrite()
+				return
+			}
+			if readErr != nil {
+				// timeout or error on one side: stop everything
+				//nolint:errcheck
+				client.Conn.Close()
+				//nolint:errcheck
+				upstream.Conn.Close()
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go copyConn(upstream, client, bytesReceived)
+	go copyConn(client, upstream, bytesSent)
+	wg.Wait()
+}
@@ -0,0 +1,244 @@
+package gobis_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+)
+
+func serveTcpHandler(handler *TcpHandler) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	//nolint:errcheck
+	go handler.Serve(ln)
+	return ln
+}
+
+func createEchoServer(prefix string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				//nolint:errcheck
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				//nolint:errcheck
+				io.WriteString(conn, prefix+line)
+			}(conn)
+		}
+	}()
+	return ln
+}
+
+func sendLine(addr, line string) string {
+	conn, err := net.Dial("tcp", addr)
+	Expect(err).NotTo(HaveOccurred())
+	//nolint:errcheck
+	defer conn.Close()
+	_, err = io.WriteString(conn, line)
+	Expect(err).NotTo(HaveOccurred())
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	Expect(err).NotTo(HaveOccurred())
+	return resp
+}
+
+var _ = Describe("TcpHandler", func() {
+	Context("routing by listening port", func() {
+		It("should forward raw connection to upstream and count bytes", func() {
+			echo := createEchoServer("echo: ")
+			//nolint:errcheck
+			defer echo.Close()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			handler, err := NewTcpHandler(TcpHandlerConfig{
+				Routes: []TcpRoute{
+					{
+						Name:       "other",
+						ListenPort: 1,
+						Upstream:   "127.0.0.1:1",
+					},
+					{
+						Name:       "echo",
+						ListenPort: ln.Addr().(*net.TCPAddr).Port,
+						Upstream:   echo.Addr().String(),
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			//nolint:errcheck
+			defer handler.Close()
+			//nolint:errcheck
+			go handler.Serve(ln)
+
+			Expect(sendLine(ln.Addr().String(), "hello\n")).To(Equal("echo: hello\n"))
+
+			Eventually(func() int64 {
+				stats, _ := handler.Stats("echo")
+				return stats.BytesSent
+			}).Should(Equal(int64(len("echo: hello\n"))))
+			stats, ok := handler.Stats("echo")
+			Expect(ok).To(BeTrue())
+			Expect(stats.BytesReceived).To(Equal(int64(len("hello\n"))))
+			Expect(stats.TotalConnections).To(Equal(int64(1)))
+			otherStats, _ := handler.Stats("other")
+			Expect(otherStats.TotalConnections).To(Equal(int64(0)))
+		})
+	})
+	Context("routing by sni", func() {
+		It("should forward tls connection without terminating it", func() {
+			backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				//nolint:errcheck
+				io.WriteString(w, "tls backend "+req.TLS.ServerName)
+			}))
+			defer backend.Close()
+			fallback := createEchoServer("fallback: ")
+			//nolint:errcheck
+			defer fallback.Close()
+
+			handler, err := NewTcpHandler(TcpHandlerConfig{
+				Routes: []TcpRoute{
+					{
+						Name:     "db",
+						SniHosts: HostMatchers{NewHostMatcher("*.db.local")},
+						Upstream: backend.Listener.Addr().String(),
+					},
+					{
+						Name:     "fallback",
+						Upstream: fallback.Addr().String(),
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			//nolint:errcheck
+			defer handler.Close()
+			ln := serveTcpHandler(handler)
+
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, ln.Addr().String())
+				},
+				//nolint:gosec
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}}
+			resp, err := client.Get("https://postgres.db.local/")
+			Expect(err).NotTo(HaveOccurred())
+			content, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("tls backend postgres.db.local"))
+
+			Expect(sendLine(ln.Addr().String(), "plain\n")).To(Equal("fallback: plain\n"))
+		})
+	})
+	Context("routing by listening port and sni", func() {
+		It("should not wait for client hello on ports without sni routes", func() {
+			greeter, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			//nolint:errcheck
+			defer greeter.Close()
+			go func() {
+				for {
+					conn, err := greeter.Accept()
+					if err != nil {
+						return
+					}
+					//nolint:errcheck
+					io.WriteString(conn, "220 ready\n")
+					//nolint:errcheck
+					conn.Close()
+				}
+			}()
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			handler, err := NewTcpHandler(TcpHandlerConfig{
+				Routes: []TcpRoute{
+					{
+						Name:       "tls",
+						ListenPort: 1,
+						SniHosts:   HostMatchers{NewHostMatcher("*.local")},
+						Upstream:   "127.0.0.1:1",
+					},
+					{
+						Name:       "greeter",
+						ListenPort: ln.Addr().(*net.TCPAddr).Port,
+						Upstream:   greeter.Addr().String(),
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			//nolint:errcheck
+			defer handler.Close()
+			//nolint:errcheck
+			go handler.Serve(ln)
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			//nolint:errcheck
+			defer conn.Close()
+			Expect(conn.SetReadDeadline(time.Now().Add(2 * time.Second))).To(Succeed())
+			greeting, err := bufio.NewReader(conn).ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			Expect(greeting).To(Equal("220 ready\n"))
+		})
+	})
+	Context("proxy protocol", func() {
+		It("should read proxy protocol from client and send it to upstream", func() {
+			upstream, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			//nolint:errcheck
+			defer upstream.Close()
+			go func() {
+				conn, err := upstream.Accept()
+				if err != nil {
+					return
+				}
+				//nolint:errcheck
+				defer conn.Close()
+				header, _ := bufio.NewReader(conn).ReadString('\n')
+				//nolint:errcheck
+				io.WriteString(conn, header)
+			}()
+
+			handler, err := NewTcpHandler(TcpHandlerConfig{
+				AcceptProxyProtocol: true,
+				Routes: []TcpRoute{
+					{
+						Name:              "proxied",
+						Upstream:          upstream.Addr().String(),
+						SendProxyProtocol: true,
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			//nolint:errcheck
+			defer handler.Close()
+			ln := serveTcpHandler(handler)
+			port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+			resp := sendLine(ln.Addr().String(), "PROXY TCP4 10.0.0.1 127.0.0.1 5678 "+port+"\r\n")
+			Expect(resp).To(Equal("PROXY TCP4 10.0.0.1 127.0.0.1 5678 " + port + "\r\n"))
+		})
+	})
+	It("should refuse invalid routes", func() {
+		_, err := NewTcpHandler(TcpHandlerConfig{
+			Routes: []TcpRoute{{Name: "invalid", Upstream: "no-port"}},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,79 @@
+package gobis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+type TcpRoute struct {
+	// Name of your tcp route
+	Name string `json:"name" yaml:"name"`
+	// ListenPort Only connections received on this local port will be routed to this route
+	// Set to 0 to accept connections received on any port
+	ListenPort int `json:"listen_port" yaml:"listen_port"`
+	// SniHosts Only TLS connections which send a server name (SNI) matching one of these hosts will be routed to this route
+	// TLS is never terminated by gobis, client hello is only read to find the server name
+	// Wildcard are allowed, e.g.: *.db.my.domain.com
+	// If empty, every connections received on ListenPort are routed to this route
+	// Client hello is awaited (10s max) only on ports where a route with sni hosts can be chosen,
+	// protocols where server speaks first (e.g.: smtp or mysql) should use a dedicated port
+	SniHosts HostMatchers `json:"sni_hosts" yaml:"sni_hosts"`
+	// Upstream Address in the form host:port where connections will be forwarded
+	Upstream string `json:"upstream" yaml:"upstream"`
+	// SendProxyProtocol Set to true to send a PROXY protocol (v1) header to upstream with real client address
+	SendProxyProtocol bool `json:"send_proxy_protocol" yaml:"send_proxy_protocol"`
+	// IdleTimeout Close connection when no data has been exchanged during this time (default: value set in TcpHandlerConfig)
+	IdleTimeout Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	// DialTimeout Maximum time to connect to upstream (default: 30s)
+	DialTimeout Duration `json:"dial_timeout" yaml:"dial_timeout"`
+}
+
+func (r *TcpRoute) UnmarshalJSON(data []byte) error {
+	type plain TcpRoute
+	err := json.Unmarshal(data, (*plain)(r))
+	if err != nil {
+		return err
+	}
+	return r.Check()
+}
+
+func (r *TcpRoute) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TcpRoute
+	var err error
+	if err = unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	return r.Check()
+}
+
+func (r TcpRoute) Check() error {
+	if r.Name == "" {
+		return fmt.Errorf("you must provide a name to your tcp routes")
+	}
+	if r.Upstream == "" {
+		return fmt.Errorf("you must provide an upstream to your tcp routes")
+	}
+	_, port, err := net.SplitHostPort(r.Upstream)
+	if err != nil {
+		return fmt.Errorf("invalid upstream: %s", err.Error())
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return fmt.Errorf("invalid upstream: port must be a number")
+	}
+	if r.ListenPort < 0 || r.ListenPort > 65535 {
+		return fmt.Errorf("invalid listen_port: must be between 0 and 65535")
+	}
+	return nil
+}
+
+func (r TcpRoute) match(localPort int, serverName string) bool {
+	if r.ListenPort != 0 && r.ListenPort != localPort {
+		return false
+	}
+	if len(r.SniHosts) == 0 {
+		return true
+	}
+	return serverName != "" && r.SniHosts.Match(serverName)
+}
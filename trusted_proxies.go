@@ -0,0 +1,165 @@
+package gobis
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	XForwardedPort = "X-Forwarded-Port"
+	XRealIp        = "X-Real-Ip"
+	Forwarded      = "Forwarded"
+)
+
+// forwardingHeaders Headers set by proxies in front of gobis
+var forwardingHeaders = []string{
+	XForwardedProto,
+	XForwardedFor,
+	XForwardedHost,
+	XForwardedPort,
+	XForwardedServer,
+	XRealIp,
+	Forwarded,
+}
+
+// trustedProxies List of networks from which forwarding headers are accepted
+// An empty list trust every proxy
+type trustedProxies []*net.IPNet
+
+func parseTrustedProxies(cidrs []string) (trustedProxies, error) {
+	proxies := make(trustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s: not an ip address or a cidr", cidr)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %s", cidr, err.Error())
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+func (t trustedProxies) isTrusted(ip string) bool {
+	if len(t) == 0 {
+		return true
+	}
+	parsedIp := net.ParseIP(ip)
+	if parsedIp == nil {
+		return false
+	}
+	for _, ipNet := range t {
+		if ipNet.Contains(parsedIp) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleForwardingHeaders Reset forwarding headers when request doesn't come from a trusted proxy
+// and set real client ip in request context
+// Client ip is the first address, from the right of X-Forwarded-For (or Forwarded), which is not a trusted proxy
+func (t trustedProxies) handleForwardingHeaders(req *http.Request) {
+	peerIp := remoteIP(req)
+	if !t.isTrusted(peerIp) {
+		for _, header := range forwardingHeaders {
+			req.Header.Del(header)
+		}
+		req.Header.Set(XRealIp, peerIp)
+		SetClientIP(req, peerIp)
+		return
+	}
+	clientIp := peerIp
+	chain := forwardedForChain(req.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if net.ParseIP(chain[i]) == nil {
+			break
+		}
+		clientIp = chain[i]
+		if !t.isTrusted(clientIp) {
+			break
+		}
+	}
+	SetClientIP(req, clientIp)
+	if len(t) == 0 {
+		// keep previous behaviour when no trusted proxies are configured
+		return
+	}
+	req.Header.Set(XRealIp, clientIp)
+}
+
+// forwardedForChain Retrieve list of ip addresses from X-Forwarded-For or from for parameters of Forwarded header
+func forwardedForChain(headers http.Header) []string {
+	chain := make([]string, 0)
+	if values := headers.Values(XForwardedFor); len(values) > 0 {
+		for _, value := range values {
+			for _, ip := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(ip))
+			}
+		}
+		return chain
+	}
+	for _, value := range headers.Values(Forwarded) {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, forwardedNodeIp(val))
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedNodeIp Extract ip from a node of Forwarded header, e.g.: "[2001:db8::1]:4711" or 192.0.2.43
+func forwardedNodeIp(node string) string {
+	node = strings.Trim(node, `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
+
+// forwardedElement Create an element of Forwarded header (RFC 7239) for a request
+func forwardedElement(req *http.Request) string {
+	params := make([]string, 0, 3)
+	if ip := remoteIP(req); ip != "" {
+		params = append(params, "for="+forwardedNodeValue(ip))
+	}
+	if host := req.Header.Get(XForwardedHost); host != "" {
+		params = append(params, "host="+forwardedQuote(host))
+	}
+	if proto := req.Header.Get(XForwardedProto); proto != "" {
+		params = append(params, "proto="+proto)
+	}
+	return strings.Join(params, ";")
+}
+
+func forwardedNodeValue(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func forwardedQuote(value string) string {
+	if strings.ContainsAny(value, ":[]") {
+		return `"` + value + `"`
+	}
+	return value
+}
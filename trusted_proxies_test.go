@@ -0,0 +1,101 @@
+package gobis_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/orange-cloudfoundry/gobis"
+	"net/http"
+	"net/http/httptest"
+)
+
+type clientIPMiddleware struct{}
+
+func (clientIPMiddleware) Handler(_ ProxyRoute, _ interface{}, next http.Handler) (http.Handler, error) {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Test-Client-Ip", ClientIP(req))
+		next.ServeHTTP(w, req)
+	}), nil
+}
+func (clientIPMiddleware) Schema() interface{} {
+	return struct{}{}
+}
+
+var _ = Describe("TrustedProxies", func() {
+	var backend *httptest.Server
+	var receivedHeaders http.Header
+	BeforeEach(func() {
+		backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			receivedHeaders = req.Header.Clone()
+			//nolint:errcheck
+			json.NewEncoder(w).Encode(req.Header)
+		}))
+	})
+	AfterEach(func() {
+		backend.Close()
+	})
+	newHandler := func(config DefaultHandlerConfig) http.Handler {
+		config.Routes = []ProxyRoute{
+			{
+				Name:    "app",
+				Path:    NewPathMatcher("/app/**"),
+				Url:     backend.URL,
+				NoProxy: true,
+			},
+		}
+		handler, err := NewDefaultHandler(config, &clientIPMiddleware{})
+		Expect(err).NotTo(HaveOccurred())
+		return handler
+	}
+	serve := func(handler http.Handler, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://gobis.local/app/path", nil)
+		req.RemoteAddr = remoteAddr
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	It("should reset forwarding headers sent by an untrusted client", func() {
+		handler := newHandler(DefaultHandlerConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+		recorder := serve(handler, "192.168.1.1:1234", map[string]string{
+			"X-Forwarded-For":   "1.2.3.4",
+			"X-Forwarded-Host":  "forged.host",
+			"X-Real-Ip":         "1.2.3.4",
+			"Forwarded":         "for=1.2.3.4",
+			"X-Forwarded-Proto": "https",
+		})
+		Expect(recorder.Code).Should(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("X-Test-Client-Ip")).Should(Equal("192.168.1.1"))
+		Expect(receivedHeaders.Get("X-Forwarded-For")).Should(Equal("192.168.1.1"))
+		Expect(receivedHeaders.Get("X-Forwarded-Host")).Should(Equal("gobis.local"))
+		Expect(receivedHeaders.Get("X-Forwarded-Proto")).Should(Equal("http"))
+		Expect(receivedHeaders.Get("X-Real-Ip")).Should(Equal("192.168.1.1"))
+		Expect(receivedHeaders.Get("Forwarded")).Should(BeEmpty())
+	})
+	It("should resolve client ip from headers sent by trusted proxies", func() {
+		handler := newHandler(DefaultHandlerConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+		recorder := serve(handler, "10.0.0.2:1234", map[string]string{
+			"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 192.168.1.1",
+		})
+		Expect(recorder.Header().Get("X-Test-Client-Ip")).Should(Equal("1.2.3.4"))
+		Expect(receivedHeaders.Get("X-Forwarded-For")).Should(Equal("6.6.6.6, 1.2.3.4, 192.168.1.1, 10.0.0.2"))
+		Expect(receivedHeaders.Get("X-Real-Ip")).Should(Equal("1.2.3.4"))
+	})
+	It("should send Forwarded header when asked", func() {
+		handler := newHandler(DefaultHandlerConfig{
+			TrustedProxies:      []string{"10.0.0.0/8"},
+			SendForwardedHeader: true,
+		})
+		recorder := serve(handler, "10.0.0.2:1234", map[string]string{
+			"Forwarded": `for="[2001:db8::1]:4711";proto=https`,
+		})
+		Expect(recorder.Header().Get("X-Test-Client-Ip")).Should(Equal("2001:db8::1"))
+		Expect(receivedHeaders.Get("Forwarded")).Should(Equal(`for="[2001:db8::1]:4711";proto=https, for=10.0.0.2;host=gobis.local;proto=http`))
+	})
+	It("should refuse invalid trusted proxies", func() {
+		_, err := NewDefaultHandler(DefaultHandlerConfig{TrustedProxies: []string{"not-a-cidr"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -2,10 +2,66 @@ package gobis
 
 import (
 	"encoding/json"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
 	"reflect"
+	"time"
 )
 
+// Duration A time.Duration which can be loaded from a config file as a string, e.g.: `30s`, `5m` or `1h30m`
+// or as a number of seconds
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return d.load(raw)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	return d.load(raw)
+}
+
+func (d *Duration) load(raw interface{}) error {
+	switch value := raw.(type) {
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %s", value, err.Error())
+		}
+		*d = Duration(duration)
+	case float64:
+		*d = Duration(time.Duration(value * float64(time.Second)))
+	case int:
+		*d = Duration(time.Duration(value) * time.Second)
+	case int64:
+		*d = Duration(time.Duration(value) * time.Second)
+	default:
+		return fmt.Errorf("invalid duration %v: must be a string like 30s or a number of seconds", raw)
+	}
+	return nil
+}
+
 func InterfaceToMap(is ...interface{}) map[string]interface{} {
 	finalMap := make(map[string]interface{})
 	for _, i := range is {
@@ -30,3 +86,12 @@ func interfaceToMap(i interface{}) map[string]interface{} {
 func GetMiddlewareName(i interface{}) string {
 	return reflect.ValueOf(i).Elem().Type().Name()
 }
+
+// remoteIP Ip address of the peer which sent request
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -5,6 +5,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/orange-cloudfoundry/gobis"
+	"time"
 )
 
 type TestStruct struct {
@@ -52,4 +53,28 @@ var _ = Describe("Utils", func() {
 
 		})
 	})
+	Context("Duration", func() {
+		It("should load a duration from a string", func() {
+			var d Duration
+			Expect(d.UnmarshalJSON([]byte(`"1m30s"`))).To(Succeed())
+			Expect(d.Duration()).Should(Equal(90 * time.Second))
+		})
+		It("should load a number as seconds", func() {
+			var d Duration
+			Expect(d.UnmarshalJSON([]byte(`30`))).To(Succeed())
+			Expect(d.Duration()).Should(Equal(30 * time.Second))
+
+			err := d.UnmarshalYAML(func(v interface{}) error {
+				*(v.(*interface{})) = 5
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.Duration()).Should(Equal(5 * time.Second))
+		})
+		It("should reject invalid durations", func() {
+			var d Duration
+			Expect(d.UnmarshalJSON([]byte(`"30 seconds"`))).NotTo(Succeed())
+			Expect(d.UnmarshalJSON([]byte(`true`))).NotTo(Succeed())
+		})
+	})
 })